@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -15,24 +17,229 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 )
 
-var Conn *sql.DB
-
 func main() {
-	tax.ConnectDb()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	handler := newHandler(ctx)
 
 	e := echo.New()
-	registerRoutes(e)
+	e.IPExtractor = getIPExtractor()
+	registerRoutes(e, handler)
 	startServer(e)
 }
 
+const migrateUsage = "usage: migrate up | down [steps] | status | force <version>"
+
+func runMigrate(args []string) {
+	if os.Getenv("DATABASE_URL") == "" {
+		log.Fatal("DATABASE_URL is not set")
+	}
+
+	config := tax.LoadDBConfig()
+	migrator, err := tax.NewMigrator(tax.ConnectDb(config), config.Dialect)
+	if err != nil {
+		log.Fatal("Cannot load migrations.", err)
+	}
+
+	if err := migrate(context.Background(), migrator, args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func migrate(ctx context.Context, migrator *tax.Migrator, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		version, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Schema is at version %d", version)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New("steps should be a positive number")
+			}
+		}
+
+		version, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Printf("Schema is at version %d", version)
+
+	case "status":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Schema is at version %d, latest is %d", version, migrator.LatestVersion())
+
+	case "force":
+		if len(args) < 2 {
+			return errors.New(migrateUsage)
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return errors.New("version should be a number")
+		}
+
+		if err := migrator.Force(ctx, version); err != nil {
+			return err
+		}
+		log.Printf("Schema is marked as version %d", version)
+
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
+
+func newHandler(ctx context.Context) *tax.Handler {
+	if os.Getenv("DATABASE_URL") == "" {
+		log.Println("DATABASE_URL is not set, using in-memory settings")
+		store := tax.NewMemoryStore(tax.DefaultAllowanceSettings)
+		handler := tax.NewHandler(store, store)
+		startBatchWorkers(ctx, handler, store)
+		return handler
+	}
+
+	config := tax.LoadDBConfig()
+	db := tax.ConnectDb(config)
+	migrator := checkSchemaVersion(db, config.Dialect)
+
+	store := tax.NewSQLStore(db, config)
+	maxStaleness := tax.GetEnvDuration("SETTINGS_CACHE_MAX_STALENESS", tax.DefaultSettingsCacheMaxStaleness)
+	settings := tax.NewSettingsCache(store, maxStaleness)
+
+	if _, err := settings.Refresh(context.Background()); err != nil {
+		log.Fatal("Cannot load settings.", err)
+	}
+
+	listeners := []tax.SettingsChangeListener{settings}
+	handler := tax.NewHandler(settings, store)
+
+	if replicaConfig, ok := config.ReadReplica(); ok {
+		if config.Dialect != tax.DialectPostgres || replicaConfig.Dialect != tax.DialectPostgres {
+			log.Fatal("DATABASE_READ_URL is only supported with PostgreSQL")
+		}
+
+		replicaStore := tax.NewSQLStore(tax.ConnectDb(replicaConfig), replicaConfig)
+		replica := tax.NewReadReplica(settings, tax.NewSettingsCache(replicaStore, maxStaleness))
+
+		listeners = append(listeners, replica)
+		handler = tax.NewHandler(replica, store)
+		handler.UseCalculationSettings(replica.Calculations())
+		handler.AddReadinessCheck("database-replica", replicaStore.Ping)
+	}
+
+	// a SQLite file has a single server writing to it, which already
+	// invalidates its own cache on every change
+	if config.Dialect == tax.DialectPostgres {
+		if err := tax.ListenSettingsChanges(context.Background(), config.URL, listeners...); err != nil {
+			log.Fatal("Cannot listen for settings changes.", err)
+		}
+	}
+
+	handler.AddReadinessCheck("database", store.Ping)
+	handler.AddReadinessCheck("migrations", migrator.CheckVersion)
+	startBatchWorkers(ctx, handler, store)
+
+	return handler
+}
+
+func checkSchemaVersion(db *sql.DB, dialect tax.Dialect) *tax.Migrator {
+	migrator, err := tax.NewMigrator(db, dialect)
+	if err != nil {
+		log.Fatal("Cannot load migrations.", err)
+	}
+
+	ctx := context.Background()
+
+	if autoMigrate(dialect) {
+		if _, err := migrator.Up(ctx); err != nil {
+			log.Fatal("Cannot migrate database.", err)
+		}
+	}
+
+	if err := migrator.CheckVersion(ctx); err != nil {
+		log.Fatal("Refusing to start: ", err)
+	}
+
+	return migrator
+}
+
+// autoMigrate follows AUTO_MIGRATE when it is set. An embedded SQLite file
+// has a single server owning it, so it is migrated by default and
+// go run main.go works on a new file.
+func autoMigrate(dialect tax.Dialect) bool {
+	value := os.Getenv("AUTO_MIGRATE")
+	if value == "" {
+		return dialect == tax.DialectSQLite
+	}
+
+	return value == "true"
+}
+
+func startBatchWorkers(ctx context.Context, handler *tax.Handler, store tax.JobStore) {
+	// 0 keeps the defaults of the handler
+	handler.UseRowWorkers(tax.GetEnvInt("CSV_WORKERS", 0), tax.GetEnvInt("CSV_WORKERS_PER_UPLOAD", 0))
+	handler.UseJobLimits(int64(tax.GetEnvInt("CSV_JOB_MAX_UPLOAD_MB", 0))<<20, tax.GetEnvDuration("CSV_JOB_RETENTION", tax.DefaultJobRetention))
+	handler.StartJobs(ctx, store)
+}
+
+func getIPExtractor() echo.IPExtractor {
+	extractIP, err := tax.ClientIPExtractor(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal("TRUSTED_PROXIES should be a comma separated list of CIDRs: ", err)
+	}
+
+	return extractIP
+}
+
 func addBasicAuthMiddleware() echo.MiddlewareFunc {
-	authFn := func(username, password string, ctx echo.Context) (bool, error) {
-		adminUser, adminPassword := prepareAdminUserPass(os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD"))
+	credentials := loadAdminCredentials()
+	if len(credentials) < 2 {
+		log.Println("Only one admin is configured, deduction proposals cannot be approved until ADMIN_USERS adds another")
+	}
+
+	return middleware.BasicAuth(credentials.Validate)
+}
+
+// loadAdminCredentials combines ADMIN_USERNAME/ADMIN_PASSWORD with the
+// username:password list in ADMIN_USERS.
+func loadAdminCredentials() tax.AdminCredentials {
+	credentials, err := tax.ParseAdminCredentials(os.Getenv("ADMIN_USERS"))
+	if err != nil {
+		log.Fatal("ADMIN_USERS is invalid: ", err)
+	}
 
-		return username == adminUser && password == adminPassword, nil
+	username, password := os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")
+	if username == "" && password == "" && len(credentials) > 0 {
+		return credentials
 	}
 
-	return middleware.BasicAuth(authFn)
+	adminUser, adminPassword := prepareAdminUserPass(username, password)
+	if _, exists := credentials[adminUser]; exists {
+		log.Fatalf("Admin %q is set in both ADMIN_USERNAME and ADMIN_USERS", adminUser)
+	}
+	credentials[adminUser] = adminPassword
+
+	return credentials
 }
 
 func prepareAdminUserPass(username, password string) (adminUsername, adminPassword string) {
@@ -43,12 +250,32 @@ func prepareAdminUserPass(username, password string) (adminUsername, adminPasswo
 	return username, password
 }
 
-func registerRoutes(e *echo.Echo) {
+func registerRoutes(e *echo.Echo, h *tax.Handler) {
+	e.Use(middleware.RequestID())
+
 	e.GET("/", handleRoot)
-	e.POST("/tax/calculations", tax.CalculateTax)
-	e.POST("/tax/calculations/upload-csv", tax.CalculateTaxWithCSV)
-	e.POST("/admin/deductions/personal", tax.SetPersonalAllowanceAmount, addBasicAuthMiddleware())
-	e.POST("/admin/deductions/k-receipt", tax.SetKReceiptAllowanceAmount, addBasicAuthMiddleware())
+	e.GET("/healthz", h.Healthz)
+	e.GET("/readyz", h.Readyz)
+	e.POST("/tax/calculations", h.CalculateTax)
+	e.POST("/tax/calculations/upload-csv", h.CalculateTaxWithCSV)
+	e.GET("/tax/jobs/:id", h.GetTaxJob)
+	e.GET("/tax/jobs/:id/result", h.GetTaxJobResult)
+	e.POST("/tax/jobs/:id/cancel", h.CancelTaxJob)
+
+	admin := e.Group("/admin", h.AuditAdminRequests(), addBasicAuthMiddleware())
+	admin.GET("/deductions/personal", h.GetPersonalAllowanceAmount)
+	admin.POST("/deductions/personal", h.SetPersonalAllowanceAmount)
+	admin.GET("/deductions/k-receipt", h.GetKReceiptAllowanceAmount)
+	admin.POST("/deductions/k-receipt", h.SetKReceiptAllowanceAmount)
+	admin.GET("/deductions/proposals", h.ListDeductionProposals)
+	admin.POST("/deductions/proposals/:id/approve", h.ApproveDeductionProposal)
+	admin.POST("/deductions/proposals/:id/reject", h.RejectDeductionProposal)
+	admin.GET("/deductions/versions", h.ListAllowanceVersions)
+	admin.GET("/deductions/versions/diff", h.DiffAllowanceVersions)
+	admin.GET("/deductions/versions/:id", h.GetAllowanceVersion)
+	admin.POST("/deductions/versions/:id/rollback", h.RollbackAllowanceVersion)
+	admin.GET("/audit", h.ListAuditEntries)
+	admin.GET("/audit/export", h.ExportAuditEntries)
 }
 
 func handleRoot(c echo.Context) error {
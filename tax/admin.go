@@ -1,55 +1,149 @@
 package tax
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
-func SetPersonalAllowanceAmount(c echo.Context) error {
-	var requestBody Allowances
+const AdminUsernameContextKey = "adminUsername"
 
-	if err := c.Bind(&requestBody); err != nil {
-		return err
-	}
+const (
+	headerETag    = "ETag"
+	headerIfMatch = "If-Match"
 
-	if requestBody.Amount > 100000 || requestBody.Amount < 10000 {
-		errorMessage := "mininum is 10000 and cannot be greater than 100000"
-		return c.String(http.StatusBadRequest, errorMessage)
+	anyVersion     int64 = 0
+	unknownVersion int64 = -1
+)
+
+func (h *Handler) GetPersonalAllowanceAmount(c echo.Context) error {
+	settings, err := h.settings.LoadSettings(c.Request().Context())
+	if err != nil {
+		return storeErrorResponse(c, err)
 	}
 
-	_, err := conn.Exec("UPDATE allowances SET personal = $1 WHERE id = $2", requestBody.Amount, 1)
+	c.Response().Header().Set(headerETag, settingsETag(settings.Version))
+
+	return c.JSON(http.StatusOK, AllowancesPersonalDeduction{
+		PersonalDeduction: settings.Allowances.Personal,
+	})
+}
+
+func (h *Handler) GetKReceiptAllowanceAmount(c echo.Context) error {
+	settings, err := h.settings.LoadSettings(c.Request().Context())
 	if err != nil {
-		return err
+		return storeErrorResponse(c, err)
 	}
 
-	allowancesDeduction := AllowancesPersonalDeduction{
-		PersonalDeduction: requestBody.Amount,
+	c.Response().Header().Set(headerETag, settingsETag(settings.Version))
+
+	return c.JSON(http.StatusOK, AllowancesKReceiptDeduction{
+		KReceipt: settings.Allowances.KReceipt,
+	})
+}
+
+func (h *Handler) SetPersonalAllowanceAmount(c echo.Context) error {
+	var requestBody AllowanceAmountRequest
+
+	if err := c.Bind(&requestBody); err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, allowancesDeduction)
+	return h.proposeAllowanceAmount(c, "personal", requestBody)
 }
 
-func SetKReceiptAllowanceAmount(c echo.Context) error {
-	var requestBody Allowances
+func (h *Handler) SetKReceiptAllowanceAmount(c echo.Context) error {
+	var requestBody AllowanceAmountRequest
 
 	if err := c.Bind(&requestBody); err != nil {
 		return err
 	}
 
-	if requestBody.Amount > 100000 || requestBody.Amount < 0 {
-		errorMessage := "mininum is 0 and cannot be greater than 100000"
+	return h.proposeAllowanceAmount(c, "k-receipt", requestBody)
+}
+
+func (h *Handler) proposeAllowanceAmount(c echo.Context, allowanceType string, requestBody AllowanceAmountRequest) error {
+	limit, err := h.settings.DeductionLimit(c.Request().Context(), allowanceType)
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	if requestBody.Amount > limit.Max || requestBody.Amount < limit.Min {
+		errorMessage := fmt.Sprintf("mininum is %s and cannot be greater than %s", formatLimit(limit.Min), formatLimit(limit.Max))
 		return c.String(http.StatusBadRequest, errorMessage)
 	}
 
-	_, err := conn.Exec(`UPDATE allowances SET "k-receipt" = $1 WHERE id = $2`, requestBody.Amount, 1)
+	expectedVersion, ok := getIfMatchVersion(c)
+	if !ok {
+		return c.String(http.StatusPreconditionRequired, "If-Match header is required")
+	}
+
+	settings, err := h.settings.LoadSettings(c.Request().Context())
 	if err != nil {
-		return err
+		return storeErrorResponse(c, err)
+	}
+
+	if !versionMatches(expectedVersion, settings.Version) {
+		c.Response().Header().Set(headerETag, settingsETag(settings.Version))
+		return c.JSON(http.StatusPreconditionFailed, allowanceDeduction(allowanceType, settings.Allowances))
+	}
+
+	proposal, err := h.settings.CreateProposal(c.Request().Context(), DeductionProposal{
+		AllowanceType: allowanceType,
+		Amount:        requestBody.Amount,
+		Reason:        requestBody.Reason,
+		BaseVersion:   settings.Version,
+		ProposedBy:    getAdminUsername(c),
+	})
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, proposal)
+}
+
+func formatLimit(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+func allowanceDeduction(allowanceType string, settings AllowanceSettings) any {
+	if allowanceType == "k-receipt" {
+		return AllowancesKReceiptDeduction{KReceipt: settings.KReceipt}
+	}
+
+	return AllowancesPersonalDeduction{PersonalDeduction: settings.Personal}
+}
+
+func getAdminUsername(c echo.Context) string {
+	username, _ := c.Get(AdminUsernameContextKey).(string)
+	return username
+}
+
+func settingsETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func getIfMatchVersion(c echo.Context) (int64, bool) {
+	ifMatch := strings.TrimSpace(c.Request().Header.Get(headerIfMatch))
+	if ifMatch == "" {
+		return 0, false
+	}
+
+	if ifMatch == "*" {
+		return anyVersion, true
+	}
+
+	if !strings.HasPrefix(ifMatch, `"`) || !strings.HasSuffix(ifMatch, `"`) {
+		return unknownVersion, true
 	}
 
-	allowancesDeduction := AllowancesKReceiptDeduction{
-		KReceipt: requestBody.Amount,
+	version, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return unknownVersion, true
 	}
 
-	return c.JSON(http.StatusOK, allowancesDeduction)
+	return version, true
 }
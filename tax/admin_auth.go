@@ -0,0 +1,50 @@
+package tax
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminCredentials maps the username of every admin to their password. A
+// deduction change needs two of them, one to propose and one to approve.
+type AdminCredentials map[string]string
+
+// ParseAdminCredentials reads a comma separated list of username:password
+// pairs, the format of ADMIN_USERS.
+func ParseAdminCredentials(list string) (AdminCredentials, error) {
+	credentials := AdminCredentials{}
+
+	for _, pair := range strings.Split(list, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		username, password, ok := strings.Cut(pair, ":")
+		if !ok || username == "" || password == "" {
+			return nil, fmt.Errorf("admin credentials should be username:password, got %q", pair)
+		}
+		if _, exists := credentials[username]; exists {
+			return nil, fmt.Errorf("admin %q is listed more than once", username)
+		}
+
+		credentials[username] = password
+	}
+
+	return credentials, nil
+}
+
+// Validate checks basic auth credentials against the admins and keeps the
+// username on the context for proposals, approvals and the audit log.
+func (a AdminCredentials) Validate(username, password string, c echo.Context) (bool, error) {
+	expected, ok := a[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return false, nil
+	}
+
+	c.Set(AdminUsernameContextKey, username)
+	return true, nil
+}
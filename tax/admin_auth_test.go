@@ -0,0 +1,79 @@
+package tax
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAdminCredentials(t *testing.T) {
+	credentials, err := ParseAdminCredentials(" maker:secret:1 , checker:pass2,")
+
+	require.NoError(t, err)
+	require.Equal(t, AdminCredentials{"maker": "secret:1", "checker": "pass2"}, credentials)
+}
+
+func TestParseAdminCredentialsWithInvalidList(t *testing.T) {
+	for _, list := range []string{"maker", "maker:", ":secret", "maker:a,maker:b"} {
+		_, err := ParseAdminCredentials(list)
+		require.Error(t, err, list)
+	}
+}
+
+func TestDeductionChangeProposedAndApprovedByTwoAdmins(t *testing.T) {
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+	credentials := AdminCredentials{"maker": "pass1", "checker": "pass2"}
+
+	e := echo.New()
+	admin := e.Group("/admin", middleware.BasicAuth(credentials.Validate))
+	admin.POST("/deductions/personal", h.SetPersonalAllowanceAmount)
+	admin.POST("/deductions/versions/:id/rollback", h.RollbackAllowanceVersion)
+	admin.POST("/deductions/proposals/:id/approve", h.ApproveDeductionProposal)
+
+	version := int64(1)
+	send := func(url, body, username, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(headerIfMatch, settingsETag(version))
+		req.SetBasicAuth(username, password)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("/admin/deductions/personal", `{"amount": 70000}`, "maker", "wrong")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = send("/admin/deductions/personal", `{"amount": 70000}`, "maker", "pass1")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = send("/admin/deductions/proposals/1/approve", `{}`, "maker", "pass1")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = send("/admin/deductions/proposals/1/approve", `{}`, "checker", "pass2")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"decidedBy":"checker"`)
+
+	// a rollback is a change of its own and needs the second admin as well
+	version = 2
+	rec = send("/admin/deductions/versions/1/rollback", `{}`, "maker", "pass1")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = send("/admin/deductions/proposals/2/approve", `{}`, "maker", "pass1")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = send("/admin/deductions/proposals/2/approve", `{}`, "checker", "pass2")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, settingsETag(3), rec.Header().Get(headerETag))
+
+	snapshot, err := store.LoadSettings(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, DefaultAllowanceSettings, snapshot.Allowances)
+}
@@ -0,0 +1,88 @@
+package tax
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) ListDeductionProposals(c echo.Context) error {
+	status := c.QueryParam("status")
+	if status == "" {
+		status = proposalStatusPending
+	}
+
+	if status != proposalStatusPending && status != proposalStatusApproved && status != proposalStatusRejected {
+		return c.String(http.StatusBadRequest, "status should be pending, approved or rejected")
+	}
+
+	proposals, err := h.settings.ListProposals(c.Request().Context(), status)
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, DeductionProposalsResponse{Proposals: proposals})
+}
+
+func (h *Handler) ApproveDeductionProposal(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "proposal id should be a number")
+	}
+
+	var requestBody ProposalDecisionRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return err
+	}
+
+	proposal, version, err := h.settings.ApproveProposal(c.Request().Context(), id, getAdminUsername(c), requestBody.Reason)
+	var changed *SettingsChangedError
+	if errors.As(err, &changed) {
+		c.Response().Header().Set(headerETag, settingsETag(changed.Current.Version))
+		if proposal.RollbackOf != nil {
+			return c.JSON(http.StatusPreconditionFailed, changed.Current)
+		}
+		return c.JSON(http.StatusPreconditionFailed, allowanceDeduction(proposal.AllowanceType, changed.Current.Allowances))
+	}
+	if err != nil {
+		return proposalDecisionError(c, err)
+	}
+
+	c.Response().Header().Set(headerETag, settingsETag(version.Version))
+
+	return c.JSON(http.StatusOK, proposal)
+}
+
+func (h *Handler) RejectDeductionProposal(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "proposal id should be a number")
+	}
+
+	var requestBody ProposalDecisionRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return err
+	}
+
+	proposal, err := h.settings.RejectProposal(c.Request().Context(), id, getAdminUsername(c), requestBody.Reason)
+	if err != nil {
+		return proposalDecisionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, proposal)
+}
+
+func proposalDecisionError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrProposalNotFound), errors.Is(err, ErrVersionNotFound):
+		return c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrProposalNotPending), errors.Is(err, ErrVersionAlreadyActive):
+		return c.String(http.StatusConflict, err.Error())
+	case errors.Is(err, ErrSelfApproval):
+		return c.String(http.StatusForbidden, err.Error())
+	default:
+		return storeErrorResponse(c, err)
+	}
+}
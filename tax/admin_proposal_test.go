@@ -0,0 +1,148 @@
+package tax
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListDeductionProposals(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	rows := mock.NewRows(deductionProposalColumns).
+		AddRow(1, "k-receipt", 70000, "", 1, proposalStatusPending, "alice", time.Now(), nil, nil, "", nil, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns + " WHERE status = $1 ORDER BY id")).
+		WithArgs(proposalStatusPending).WillReturnRows(rows)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/proposals", "")
+
+	err := h.ListDeductionProposals(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody DeductionProposalsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Len(t, responseBody.Proposals, 1)
+	require.Equal(t, "alice", responseBody.Proposals[0].ProposedBy)
+}
+
+func TestListDeductionProposalsWithInvalidStatus(t *testing.T) {
+	h, _ := setupMockHandler()
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/proposals?status=done", "")
+
+	err := h.ListDeductionProposals(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestApproveDeductionProposalHandler(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	expectAllowanceSettingsLocked(mock, 1)
+	expectAllowanceVersionSaved(mock, AllowanceSettings{Personal: 70000, Donation: 100000, KReceipt: 50000}, "bob", 1, nil, 2)
+	expectDeductionProposalDecided(mock, proposalStatusApproved, "bob", 2, 3)
+	mock.ExpectCommit()
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/proposals/3/approve", `{"reason": "ok"}`)
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+	c.Set(AdminUsernameContextKey, "bob")
+
+	err := h.ApproveDeductionProposal(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApproveDeductionProposalHandlerBySameAdminReturnForbidden(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	mock.ExpectRollback()
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/proposals/3/approve", `{}`)
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+	c.Set(AdminUsernameContextKey, "alice")
+
+	err := h.ApproveDeductionProposal(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, "proposer cannot approve their own proposal", rec.Body.String())
+}
+
+func TestApproveDeductionProposalHandlerWhenSettingsChanged(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	expectAllowanceSettingsLocked(mock, 2)
+	mock.ExpectRollback()
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/proposals/3/approve", `{}`)
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+	c.Set(AdminUsernameContextKey, "bob")
+
+	err := h.ApproveDeductionProposal(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	require.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	require.JSONEq(t, `{"personalDeduction": 60000}`, rec.Body.String())
+}
+
+func TestRejectDeductionProposalHandler(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	expectDeductionProposalDecided(mock, proposalStatusRejected, "bob", nil, 3)
+	mock.ExpectCommit()
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/proposals/3/reject", `{"reason": "too high"}`)
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+	c.Set(AdminUsernameContextKey, "bob")
+
+	err := h.RejectDeductionProposal(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody DeductionProposal
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, proposalStatusRejected, responseBody.Status)
+	require.Equal(t, "too high", responseBody.DecisionReason)
+}
+
+func TestRejectDeductionProposalHandlerNotFound(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns + " WHERE id = $1 FOR UPDATE")).WithArgs(9).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/proposals/9/reject", `{}`)
+	c.SetParamNames("id")
+	c.SetParamValues("9")
+
+	err := h.RejectDeductionProposal(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -15,29 +15,36 @@ import (
 )
 
 func TestSetPersonalAllowanceAmount(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
 	e := echo.New()
 	requestBody := Allowances{
 		Amount: 70000,
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE allowances SET personal = $1 WHERE id = $2`)).
-		WithArgs(requestBody.Amount, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectDeductionLimit(mock, "personal")
+	expectSettingsSnapshot(mock, 1)
+	expectDeductionProposalCreated(mock, "personal", 70000, 1, "adminTax", 3)
 
 	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+	c.Set(AdminUsernameContextKey, "adminTax")
+	c.Request().Header.Set("If-Match", `"1"`)
 
-	err := SetPersonalAllowanceAmount(c)
+	err := h.SetPersonalAllowanceAmount(c)
 
 	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
 	require.NotEmpty(t, rec.Body)
-	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var responseBody DeductionProposal
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, int64(3), responseBody.ID)
+	require.Equal(t, proposalStatusPending, responseBody.Status)
 }
 
 func TestSetPersonalAllowanceAmountWithInvalidRequest(t *testing.T) {
-	db, _ := setupMockDB()
-	conn = db
+	h, _ := setupMockHandler()
 
 	e := echo.New()
 	reqBodyJSON := `{"amount": "not a number"}`
@@ -48,74 +55,103 @@ func TestSetPersonalAllowanceAmountWithInvalidRequest(t *testing.T) {
 	c := e.NewContext(req, rec)
 	require.NotEmpty(t, c)
 
-	err := SetPersonalAllowanceAmount(c)
+	err := h.SetPersonalAllowanceAmount(c)
 
 	require.Error(t, err)
 }
 
 func TestSetPersonalAllowanceAmountWithInvalidAmount(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
 	e := echo.New()
 	requestBody := Allowances{
 		Amount: 100001,
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE allowances SET personal = $1 WHERE id = $2`)).
-		WithArgs(requestBody.Amount, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectDeductionLimit(mock, "personal")
 
 	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
 
-	_ = SetPersonalAllowanceAmount(c)
+	_ = h.SetPersonalAllowanceAmount(c)
 
 	require.NotEmpty(t, rec.Body)
 	require.Equal(t, http.StatusBadRequest, rec.Code)
 }
 
+func TestSetPersonalAllowanceAmountUsesLimitOfDeductionType(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	e := echo.New()
+	requestBody := Allowances{
+		Amount: 15000,
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT min_amount, max_amount FROM deduction_types WHERE code = $1`)).
+		WithArgs("personal").WillReturnRows(sqlmock.NewRows([]string{"min_amount", "max_amount"}).AddRow(20000, 150000))
+
+	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+
+	err := h.SetPersonalAllowanceAmount(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, "mininum is 20000 and cannot be greater than 150000", rec.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestSetPersonalAllowanceAmountButQueryError(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
 	e := echo.New()
 	requestBody := Allowances{
 		Amount: 100000,
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE allowances SET personal = $1 WHERE id = $2`)).
-		WithoutArgs().WillReturnError(sql.ErrNoRows)
+	expectDeductionLimit(mock, "personal")
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).
+		WillReturnError(sql.ErrConnDone)
 
-	_, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+	c.Request().Header.Set("If-Match", `"1"`)
 
-	err := SetPersonalAllowanceAmount(c)
+	err := h.SetPersonalAllowanceAmount(c)
 
-	require.Error(t, err)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "5", rec.Header().Get("Retry-After"))
 }
 
 func TestSetKReceiptAllowanceAmount(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
 	e := echo.New()
 	requestBody := Allowances{
 		Amount: 70000,
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE allowances SET "k-receipt" = $1 WHERE id = $2`)).
-		WithArgs(requestBody.Amount, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectDeductionLimit(mock, "k-receipt")
+	expectSettingsSnapshot(mock, 1)
+	expectDeductionProposalCreated(mock, "k-receipt", 70000, 1, "adminTax", 3)
 
 	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/k-receipt")
+	c.Set(AdminUsernameContextKey, "adminTax")
+	c.Request().Header.Set("If-Match", `"1"`)
 
-	err := SetKReceiptAllowanceAmount(c)
+	err := h.SetKReceiptAllowanceAmount(c)
 
 	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
 	require.NotEmpty(t, rec.Body)
-	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var responseBody DeductionProposal
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, int64(3), responseBody.ID)
+	require.Equal(t, proposalStatusPending, responseBody.Status)
 }
 
 func TestSetKReceiptAllowanceAmounttWithInvalidRequest(t *testing.T) {
-	db, _ := setupMockDB()
-	conn = db
+	h, _ := setupMockHandler()
 
 	e := echo.New()
 	reqBodyJSON := `{"amount": "not a number"}`
@@ -126,48 +162,166 @@ func TestSetKReceiptAllowanceAmounttWithInvalidRequest(t *testing.T) {
 	c := e.NewContext(req, rec)
 	require.NotEmpty(t, c)
 
-	err := SetKReceiptAllowanceAmount(c)
+	err := h.SetKReceiptAllowanceAmount(c)
 
 	require.Error(t, err)
 }
 
 func TestSetKReceiptAmountWithInvalidAmount(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
 	e := echo.New()
 	requestBody := Allowances{
 		Amount: 100001,
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE allowances SET "k-receipt" = $1 WHERE id = $2`)).
-		WithArgs(requestBody.Amount, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectDeductionLimit(mock, "k-receipt")
 
 	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
 
-	_ = SetKReceiptAllowanceAmount(c)
+	_ = h.SetKReceiptAllowanceAmount(c)
 
 	require.NotEmpty(t, rec.Body)
 	require.Equal(t, http.StatusBadRequest, rec.Code)
 }
 
 func TestSetKReceiptAllowanceAmountButQueryError(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
 	e := echo.New()
 	requestBody := Allowances{
 		Amount: 100000,
 	}
 
-	mock.ExpectExec(regexp.QuoteMeta(`UPDATE allowances SET "k-receipt" = $1 WHERE id = $2`)).
-		WithoutArgs().WillReturnError(sql.ErrNoRows)
+	expectDeductionLimit(mock, "k-receipt")
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).
+		WillReturnError(sql.ErrConnDone)
 
-	_, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+	c.Request().Header.Set("If-Match", `"1"`)
 
-	err := SetKReceiptAllowanceAmount(c)
+	err := h.SetKReceiptAllowanceAmount(c)
 
-	require.Error(t, err)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "5", rec.Header().Get("Retry-After"))
+}
+
+func TestSetPersonalAllowanceAmountRequiresIfMatch(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	e := echo.New()
+	requestBody := Allowances{
+		Amount: 70000,
+	}
+
+	expectDeductionLimit(mock, "personal")
+
+	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+
+	err := h.SetPersonalAllowanceAmount(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionRequired, rec.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetPersonalAllowanceAmountWithStaleIfMatch(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectDeductionLimit(mock, "personal")
+	expectSettingsSnapshot(mock, 2)
+
+	e := echo.New()
+	requestBody := Allowances{
+		Amount: 70000,
+	}
+
+	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/personal")
+	c.Request().Header.Set("If-Match", `"1"`)
+
+	err := h.SetPersonalAllowanceAmount(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	require.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	require.JSONEq(t, `{"personalDeduction": 60000}`, rec.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetKReceiptAllowanceAmountWithStaleIfMatch(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectDeductionLimit(mock, "k-receipt")
+	expectSettingsSnapshot(mock, 2)
+
+	e := echo.New()
+	requestBody := Allowances{
+		Amount: 70000,
+	}
+
+	rec, c := mockNewRequestAdmin(requestBody, t, e, "/admin/deductions/k-receipt")
+	c.Request().Header.Set("If-Match", `"1"`)
+
+	err := h.SetKReceiptAllowanceAmount(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	require.JSONEq(t, `{"kReceipt": 50000}`, rec.Body.String())
+}
+
+func TestGetPersonalAllowanceAmountReturnETag(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectSettingsSnapshot(mock, 5)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/personal", "")
+
+	err := h.GetPersonalAllowanceAmount(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"5"`, rec.Header().Get("ETag"))
+	require.JSONEq(t, `{"personalDeduction": 60000}`, rec.Body.String())
+}
+
+func TestGetKReceiptAllowanceAmountReturnETag(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectSettingsSnapshot(mock, 5)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/k-receipt", "")
+
+	err := h.GetKReceiptAllowanceAmount(c)
+
+	require.NoError(t, err)
+	require.Equal(t, `"5"`, rec.Header().Get("ETag"))
+	require.JSONEq(t, `{"kReceipt": 50000}`, rec.Body.String())
+}
+
+func TestGetIfMatchVersion(t *testing.T) {
+	testCases := []struct {
+		ifMatch         string
+		expectedVersion int64
+		expectedOk      bool
+	}{
+		{``, 0, false},
+		{`*`, anyVersion, true},
+		{`"3"`, 3, true},
+		{`W/"3"`, unknownVersion, true},
+		{`3`, unknownVersion, true},
+		{`"abc"`, unknownVersion, true},
+	}
+
+	for _, tt := range testCases {
+		_, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/personal", "")
+		c.Request().Header.Set("If-Match", tt.ifMatch)
+
+		version, ok := getIfMatchVersion(c)
+
+		require.Equal(t, tt.expectedVersion, version, tt.ifMatch)
+		require.Equal(t, tt.expectedOk, ok, tt.ifMatch)
+	}
 }
 
 func mockNewRequestAdmin(requestBody Allowances, t *testing.T, e *echo.Echo, url string) (*httptest.ResponseRecorder, echo.Context) {
@@ -182,3 +336,9 @@ func mockNewRequestAdmin(requestBody Allowances, t *testing.T, e *echo.Echo, url
 	require.NotEmpty(t, c)
 	return rec, c
 }
+
+func expectDeductionLimit(mock sqlmock.Sqlmock, allowanceType string) {
+	limit := DefaultDeductionLimits[allowanceType]
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT min_amount, max_amount FROM deduction_types WHERE code = $1`)).
+		WithArgs(allowanceType).WillReturnRows(sqlmock.NewRows([]string{"min_amount", "max_amount"}).AddRow(limit.Min, limit.Max))
+}
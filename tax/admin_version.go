@@ -0,0 +1,191 @@
+package tax
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) ListAllowanceVersions(c echo.Context) error {
+	versions, err := h.settings.ListVersions(c.Request().Context())
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	settingsByVersion := map[int64]AllowanceSettings{}
+	for _, version := range versions {
+		settingsByVersion[version.Version] = version.Settings
+	}
+
+	for i, version := range versions {
+		versions[i].Changes = []AllowanceChange{}
+		if version.PreviousVersion == nil {
+			continue
+		}
+		if previous, ok := settingsByVersion[*version.PreviousVersion]; ok {
+			versions[i].Changes = diffAllowanceSettings(previous, version.Settings)
+		}
+	}
+
+	return c.JSON(http.StatusOK, AllowanceVersionsResponse{Versions: versions})
+}
+
+func (h *Handler) GetAllowanceVersion(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "version id should be a number")
+	}
+
+	version, err := h.settings.GetVersion(c.Request().Context(), id)
+	if errors.Is(err, ErrVersionNotFound) {
+		return c.String(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	version.Changes = []AllowanceChange{}
+	if version.PreviousVersion != nil {
+		previous, err := h.settings.GetVersion(c.Request().Context(), *version.PreviousVersion)
+		if err != nil {
+			return storeErrorResponse(c, err)
+		}
+		version.Changes = diffAllowanceSettings(previous.Settings, version.Settings)
+	}
+
+	return c.JSON(http.StatusOK, version)
+}
+
+func (h *Handler) DiffAllowanceVersions(c echo.Context) error {
+	from, err := strconv.ParseInt(c.QueryParam("from"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "from and to should be version ids")
+	}
+
+	to, err := strconv.ParseInt(c.QueryParam("to"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "from and to should be version ids")
+	}
+
+	fromVersion, err := h.settings.GetVersion(c.Request().Context(), from)
+	if errors.Is(err, ErrVersionNotFound) {
+		return c.String(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	toVersion, err := h.settings.GetVersion(c.Request().Context(), to)
+	if errors.Is(err, ErrVersionNotFound) {
+		return c.String(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, AllowanceVersionDiff{
+		From:    from,
+		To:      to,
+		Changes: diffAllowanceSettings(fromVersion.Settings, toVersion.Settings),
+	})
+}
+
+func (h *Handler) RollbackAllowanceVersion(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, "version id should be a number")
+	}
+
+	var requestBody AllowanceRollbackRequest
+	if err := c.Bind(&requestBody); err != nil {
+		return err
+	}
+
+	if requestBody.Reason == "" {
+		requestBody.Reason = fmt.Sprintf("rollback to version %d", id)
+	}
+
+	expectedVersion, ok := getIfMatchVersion(c)
+	if !ok {
+		return c.String(http.StatusPreconditionRequired, "If-Match header is required")
+	}
+
+	settings, err := h.settings.LoadSettings(c.Request().Context())
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	if !versionMatches(expectedVersion, settings.Version) {
+		c.Response().Header().Set(headerETag, settingsETag(settings.Version))
+		return c.JSON(http.StatusPreconditionFailed, settings)
+	}
+
+	if id == settings.Version {
+		return c.String(http.StatusConflict, ErrVersionAlreadyActive.Error())
+	}
+
+	_, err = h.settings.GetVersion(c.Request().Context(), id)
+	if errors.Is(err, ErrVersionNotFound) {
+		return c.String(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	// a rollback changes the caps like any other change, so it waits for a second admin too
+	proposal, err := h.settings.CreateProposal(c.Request().Context(), DeductionProposal{
+		RollbackOf:  &id,
+		Reason:      requestBody.Reason,
+		BaseVersion: settings.Version,
+		ProposedBy:  getAdminUsername(c),
+	})
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusAccepted, proposal)
+}
+
+var deductionTypes = []string{"personal", "donation", "k-receipt"}
+
+func (settings AllowanceSettings) get(allowanceType string) float64 {
+	switch allowanceType {
+	case "personal":
+		return settings.Personal
+	case "donation":
+		return settings.Donation
+	case "k-receipt":
+		return settings.KReceipt
+	}
+	return 0
+}
+
+func (settings *AllowanceSettings) set(allowanceType string, amount float64) {
+	switch allowanceType {
+	case "personal":
+		settings.Personal = amount
+	case "donation":
+		settings.Donation = amount
+	case "k-receipt":
+		settings.KReceipt = amount
+	}
+}
+
+func diffAllowanceSettings(before, after AllowanceSettings) []AllowanceChange {
+	changes := []AllowanceChange{}
+
+	if before.Personal != after.Personal {
+		changes = append(changes, AllowanceChange{AllowanceType: "personal", OldAmount: before.Personal, NewAmount: after.Personal})
+	}
+	if before.Donation != after.Donation {
+		changes = append(changes, AllowanceChange{AllowanceType: "donation", OldAmount: before.Donation, NewAmount: after.Donation})
+	}
+	if before.KReceipt != after.KReceipt {
+		changes = append(changes, AllowanceChange{AllowanceType: "k-receipt", OldAmount: before.KReceipt, NewAmount: after.KReceipt})
+	}
+
+	return changes
+}
@@ -0,0 +1,236 @@
+package tax
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListAllowanceVersions(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	changedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(allowanceVersionColumns)
+	addAllowanceVersionRows(rows, 2, AllowanceSettings{Personal: 70000, Donation: 100000, KReceipt: 50000}, "adminTax", changedAt, "raise personal", 1, nil)
+	addAllowanceVersionRows(rows, 1, AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 50000}, "system", changedAt, "initial settings", nil, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " ORDER BY allowance_versions.id DESC")).WillReturnRows(rows)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions", "")
+
+	err := h.ListAllowanceVersions(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody AllowanceVersionsResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Len(t, responseBody.Versions, 2)
+	require.Equal(t, []AllowanceChange{{AllowanceType: "personal", OldAmount: 60000, NewAmount: 70000}}, responseBody.Versions[0].Changes)
+	require.Empty(t, responseBody.Versions[1].Changes)
+}
+
+func TestListAllowanceVersionsButQueryError(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions)).WillReturnError(sql.ErrConnDone)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions", "")
+
+	err := h.ListAllowanceVersions(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestGetAllowanceVersion(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	changedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(allowanceVersionColumns)
+	addAllowanceVersionRows(rows, 2, AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 70000}, "adminTax", changedAt, "", 1, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(2).WillReturnRows(rows)
+	rows = mock.NewRows(allowanceVersionColumns)
+	addAllowanceVersionRows(rows, 1, AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 50000}, "system", changedAt, "initial settings", nil, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(1).WillReturnRows(rows)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions/2", "")
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+
+	err := h.GetAllowanceVersion(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody AllowanceVersion
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, "adminTax", responseBody.ChangedBy)
+	require.Equal(t, []AllowanceChange{{AllowanceType: "k-receipt", OldAmount: 50000, NewAmount: 70000}}, responseBody.Changes)
+}
+
+func TestGetAllowanceVersionWithInvalidId(t *testing.T) {
+	h, _ := setupMockHandler()
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions/abc", "")
+	c.SetParamNames("id")
+	c.SetParamValues("abc")
+
+	err := h.GetAllowanceVersion(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestGetAllowanceVersionNotFoundReturn404(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(9).WillReturnRows(mock.NewRows(allowanceVersionColumns))
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions/9", "")
+	c.SetParamNames("id")
+	c.SetParamValues("9")
+
+	err := h.GetAllowanceVersion(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, "no version found with the specified id", rec.Body.String())
+}
+
+func TestDiffAllowanceVersions(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	changedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(allowanceVersionColumns)
+	addAllowanceVersionRows(rows, 1, AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 50000}, "system", changedAt, "initial settings", nil, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(1).WillReturnRows(rows)
+	rows = mock.NewRows(allowanceVersionColumns)
+	addAllowanceVersionRows(rows, 3, AllowanceSettings{Personal: 70000, Donation: 100000, KReceipt: 80000}, "adminTax", changedAt, "", 2, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(3).WillReturnRows(rows)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions/diff?from=1&to=3", "")
+
+	err := h.DiffAllowanceVersions(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody AllowanceVersionDiff
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, AllowanceVersionDiff{
+		From: 1,
+		To:   3,
+		Changes: []AllowanceChange{
+			{AllowanceType: "personal", OldAmount: 60000, NewAmount: 70000},
+			{AllowanceType: "k-receipt", OldAmount: 50000, NewAmount: 80000},
+		},
+	}, responseBody)
+}
+
+func TestDiffAllowanceVersionsWithMissingQuery(t *testing.T) {
+	h, _ := setupMockHandler()
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions/diff?from=1", "")
+
+	err := h.DiffAllowanceVersions(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRollbackAllowanceVersion(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	changedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(allowanceVersionColumns)
+	addAllowanceVersionRows(rows, 2, AllowanceSettings{Personal: 80000, Donation: 100000, KReceipt: 50000}, "adminTax", changedAt, "", 1, nil)
+
+	expectSettingsSnapshot(mock, 3)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(2).WillReturnRows(rows)
+	expectRollbackProposalCreated(mock, 2, 3, "adminTax", 5)
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/versions/2/rollback", `{}`)
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+	c.Set(AdminUsernameContextKey, "adminTax")
+	c.Request().Header.Set("If-Match", `"3"`)
+
+	err := h.RollbackAllowanceVersion(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var responseBody DeductionProposal
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, int64(5), responseBody.ID)
+	require.Equal(t, int64(2), *responseBody.RollbackOf)
+	require.Equal(t, proposalStatusPending, responseBody.Status)
+	require.Equal(t, "rollback to version 2", responseBody.Reason)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRollbackAllowanceVersionToActiveVersionReturnConflict(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectSettingsSnapshot(mock, 3)
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/versions/3/rollback", `{"reason": "undo"}`)
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+	c.Request().Header.Set("If-Match", `"3"`)
+
+	err := h.RollbackAllowanceVersion(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, rec.Code)
+}
+
+func TestRollbackAllowanceVersionToUnknownVersionReturn404(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectSettingsSnapshot(mock, 3)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(9).WillReturnRows(mock.NewRows(allowanceVersionColumns))
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/versions/9/rollback", `{}`)
+	c.SetParamNames("id")
+	c.SetParamValues("9")
+	c.Request().Header.Set("If-Match", `"3"`)
+
+	err := h.RollbackAllowanceVersion(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRollbackAllowanceVersionWithStaleIfMatch(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectSettingsSnapshot(mock, 4)
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/versions/2/rollback", `{}`)
+	c.SetParamNames("id")
+	c.SetParamValues("2")
+	c.Request().Header.Set("If-Match", `"3"`)
+
+	err := h.RollbackAllowanceVersion(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	require.Equal(t, `"4"`, rec.Header().Get("ETag"))
+}
+
+func mockNewRequestVersion(method, url, body string) (*httptest.ResponseRecorder, echo.Context) {
+	e := echo.New()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	return rec, e.NewContext(req, rec)
+}
@@ -0,0 +1,244 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxAuditPayloadBytes = 64 * 1024
+	defaultAuditLimit    = 50
+	maxAuditLimit        = 500
+)
+
+// ClientIPExtractor decides where the source IP of the audit log comes from.
+// Without trusted proxies it is the address of the connection, so a client
+// cannot put another IP in X-Forwarded-For. trustedProxies is a comma
+// separated list of CIDRs whose X-Forwarded-For is believed.
+func ClientIPExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	var options []echo.TrustOption
+
+	for _, proxy := range strings.Split(trustedProxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		_, ipRange, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+
+	if len(options) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options = append(options, echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false))
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+func (h *Handler) AuditAdminRequests() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			payload, err := readAuditPayload(c.Request())
+			if err != nil {
+				return err
+			}
+
+			err = next(c)
+
+			username, authenticated := getAdminUsername(c), true
+			if username == "" {
+				username, _, _ = c.Request().BasicAuth()
+				authenticated = false
+			}
+
+			entry := AuditEntry{
+				Username:      username,
+				Authenticated: authenticated,
+				SourceIP:      c.RealIP(),
+				RequestID:     getRequestID(c),
+				Method:        c.Request().Method,
+				Endpoint:      c.Request().URL.RequestURI(),
+				Payload:       payload,
+				Status:        getAuditStatus(c, err),
+			}
+
+			// the entry is still written when the client has already disconnected
+			if err := h.audit.AppendAuditEntry(context.WithoutCancel(c.Request().Context()), entry); err != nil {
+				log.Printf("Cannot write audit log for request %s: %v", entry.RequestID, err)
+			}
+
+			return err
+		}
+	}
+}
+
+func (h *Handler) ListAuditEntries(c echo.Context) error {
+	filter, err := getAuditFilter(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	entries, err := h.getAuditEntries(c.Request().Context(), filter)
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, AuditLogResponse{
+		Entries: entries,
+		Limit:   filter.Limit,
+		Offset:  filter.Offset,
+	})
+}
+
+func (h *Handler) ExportAuditEntries(c echo.Context) error {
+	filter, err := getAuditFilter(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	filter.Limit = 0
+	filter.Offset = 0
+
+	response := c.Response()
+	encoder := json.NewEncoder(response)
+
+	// the status is sent with the first entry, so a query that fails up front
+	// still gets an error response
+	writeHeader := func() {
+		if !response.Committed {
+			response.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+			response.WriteHeader(http.StatusOK)
+		}
+	}
+
+	err = h.audit.ScanAuditEntries(c.Request().Context(), filter, func(entry AuditEntry) error {
+		writeHeader()
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+		response.Flush()
+		return nil
+	})
+	if err != nil && !response.Committed {
+		return storeErrorResponse(c, err)
+	}
+	if err != nil {
+		// the status is already sent, the export just ends early
+		log.Printf("Audit export for request %s stopped: %v", getRequestID(c), err)
+		return nil
+	}
+
+	writeHeader()
+	return nil
+}
+
+func (h *Handler) getAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+
+	err := h.audit.ScanAuditEntries(ctx, filter, func(entry AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func getAuditFilter(c echo.Context) (AuditFilter, error) {
+	filter := AuditFilter{
+		Username: c.QueryParam("username"),
+		Endpoint: c.QueryParam("endpoint"),
+		Limit:    defaultAuditLimit,
+	}
+
+	var err error
+
+	if status := c.QueryParam("status"); status != "" {
+		if filter.Status, err = strconv.Atoi(status); err != nil {
+			return AuditFilter{}, errors.New("status should be a number")
+		}
+	}
+
+	if from := c.QueryParam("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return AuditFilter{}, errors.New("from and to should be RFC3339 timestamps")
+		}
+	}
+
+	if to := c.QueryParam("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return AuditFilter{}, errors.New("from and to should be RFC3339 timestamps")
+		}
+	}
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxAuditLimit {
+			return AuditFilter{}, errors.New("limit should be between 1 and 500")
+		}
+	}
+
+	if offset := c.QueryParam("offset"); offset != "" {
+		filter.Offset, err = strconv.Atoi(offset)
+		if err != nil || filter.Offset < 0 {
+			return AuditFilter{}, errors.New("offset cannot be less than 0")
+		}
+	}
+
+	return filter, nil
+}
+
+func readAuditPayload(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(req.Body, maxAuditPayloadBytes))
+	if err != nil {
+		return "", err
+	}
+
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(payload), req.Body), req.Body}
+
+	return string(payload), nil
+}
+
+func getRequestID(c echo.Context) string {
+	if requestID := c.Response().Header().Get(echo.HeaderXRequestID); requestID != "" {
+		return requestID
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+func getAuditStatus(c echo.Context, err error) int {
+	if err == nil {
+		return c.Response().Status
+	}
+
+	var httpError *echo.HTTPError
+	if errors.As(err, &httpError) {
+		return httpError.Code
+	}
+
+	return http.StatusInternalServerError
+}
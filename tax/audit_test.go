@@ -0,0 +1,237 @@
+package tax
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditAdminRequestsRecordsAuthenticatedRequest(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectAuditEntryInserted(mock, AuditEntry{
+		Username:      "adminTax",
+		Authenticated: true,
+		SourceIP:      "192.0.2.1",
+		RequestID:     "req-1",
+		Method:        http.MethodPost,
+		Endpoint:      "/admin/echo",
+		Payload:       `{"amount":70000}`,
+		Status:        http.StatusOK,
+	})
+
+	e := newAuditedAdminEcho(h)
+	req := httptest.NewRequest(http.MethodPost, "/admin/echo", strings.NewReader(`{"amount":70000}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRequestID, "req-1")
+	req.SetBasicAuth("adminTax", "admin!")
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"amount":70000}`, rec.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditAdminRequestsRecordsFailedAuthentication(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectAuditEntryInserted(mock, AuditEntry{
+		Username:      "mallory",
+		Authenticated: false,
+		SourceIP:      "192.0.2.1",
+		RequestID:     "req-2",
+		Method:        http.MethodPost,
+		Endpoint:      "/admin/echo",
+		Payload:       `{}`,
+		Status:        http.StatusUnauthorized,
+	})
+
+	e := newAuditedAdminEcho(h)
+	req := httptest.NewRequest(http.MethodPost, "/admin/echo", strings.NewReader(`{}`))
+	req.Header.Set(echo.HeaderXRequestID, "req-2")
+	req.SetBasicAuth("mallory", "guess")
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditAdminRequestsIgnoresForgedForwardedFor(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectAuditEntryInserted(mock, AuditEntry{
+		Username:      "mallory",
+		Authenticated: false,
+		SourceIP:      "192.0.2.1",
+		RequestID:     "req-3",
+		Method:        http.MethodPost,
+		Endpoint:      "/admin/echo",
+		Payload:       `{}`,
+		Status:        http.StatusUnauthorized,
+	})
+
+	e := newAuditedAdminEcho(h)
+	req := httptest.NewRequest(http.MethodPost, "/admin/echo", strings.NewReader(`{}`))
+	req.Header.Set(echo.HeaderXRequestID, "req-3")
+	req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+	req.Header.Set(echo.HeaderXRealIP, "203.0.113.7")
+	req.SetBasicAuth("mallory", "guess")
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClientIPExtractorWithTrustedProxy(t *testing.T) {
+	extractIP, err := ClientIPExtractor("192.0.2.0/24, 198.51.100.0/24")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+	require.Equal(t, "203.0.113.7", extractIP(req))
+
+	req.RemoteAddr = "203.0.113.9:1234"
+	require.Equal(t, "203.0.113.9", extractIP(req))
+
+	_, err = ClientIPExtractor("10.0.0.1")
+	require.Error(t, err)
+}
+
+func TestListAuditEntries(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	rows := mock.NewRows(auditEntryColumns).
+		AddRow(1, time.Now(), "mallory", false, "10.0.0.9", "req-1", "POST", "/admin/deductions/personal", "{}", 401)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAuditEntryColumns+" WHERE username = $1 ORDER BY id DESC LIMIT $2 OFFSET $3")).
+		WithArgs("mallory", 10, 20).WillReturnRows(rows)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/audit?username=mallory&limit=10&offset=20", "")
+
+	err := h.ListAuditEntries(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody AuditLogResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Len(t, responseBody.Entries, 1)
+	require.Equal(t, 10, responseBody.Limit)
+	require.Equal(t, 20, responseBody.Offset)
+}
+
+func TestListAuditEntriesWithInvalidFilter(t *testing.T) {
+	h, _ := setupMockHandler()
+
+	testCases := []string{
+		"/admin/audit?status=abc",
+		"/admin/audit?from=yesterday",
+		"/admin/audit?limit=0",
+		"/admin/audit?limit=501",
+		"/admin/audit?offset=-1",
+	}
+
+	for _, url := range testCases {
+		rec, c := mockNewRequestVersion(http.MethodGet, url, "")
+
+		err := h.ListAuditEntries(c)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, rec.Code, url)
+	}
+}
+
+func TestExportAuditEntries(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	rows := mock.NewRows(auditEntryColumns).
+		AddRow(2, time.Now(), "adminTax", true, "10.0.0.1", "req-2", "GET", "/admin/audit", "", 200).
+		AddRow(1, time.Now(), "mallory", false, "10.0.0.9", "req-1", "POST", "/admin/deductions/personal", "{}", 401)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAuditEntryColumns + " WHERE status = $1 ORDER BY id DESC")).
+		WithArgs(401).WillReturnRows(rows)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/audit/export?status=401", "")
+
+	err := h.ExportAuditEntries(c)
+
+	require.NoError(t, err)
+	require.Equal(t, "application/x-ndjson", rec.Header().Get(echo.HeaderContentType))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var entry AuditEntry
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Equal(t, "mallory", entry.Username)
+}
+
+func TestExportAuditEntriesEndsStreamOnError(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	rows := mock.NewRows(auditEntryColumns).
+		AddRow(2, time.Now(), "adminTax", true, "10.0.0.1", "req-2", "GET", "/admin/audit", "", 200).
+		AddRow(1, time.Now(), "mallory", false, "10.0.0.9", "req-1", "POST", "/admin/deductions/personal", "{}", 401).
+		RowError(1, sql.ErrConnDone)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAuditEntryColumns + " ORDER BY id DESC")).WillReturnRows(rows)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/audit/export", "")
+
+	err := h.ExportAuditEntries(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], `"req-2"`)
+}
+
+func TestExportAuditEntriesWhenQueryFails(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectAuditEntryColumns + " ORDER BY id DESC")).WillReturnError(sql.ErrConnDone)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/audit/export", "")
+
+	err := h.ExportAuditEntries(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, ErrStoreUnavailable.Error(), rec.Body.String())
+}
+
+func newAuditedAdminEcho(h *Handler) *echo.Echo {
+	e := echo.New()
+	e.IPExtractor, _ = ClientIPExtractor("")
+	basicAuth := middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
+		if username != "adminTax" || password != "admin!" {
+			return false, nil
+		}
+		c.Set(AdminUsernameContextKey, username)
+		return true, nil
+	})
+
+	admin := e.Group("/admin", h.AuditAdminRequests(), basicAuth)
+	admin.POST("/echo", func(c echo.Context) error {
+		var body map[string]any
+		if err := c.Bind(&body); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, body)
+	})
+
+	return e
+}
@@ -14,7 +14,7 @@ var allowedAllowances = map[string]bool{
 	"k-receipt": true,
 }
 
-func CalculateTax(c echo.Context) error {
+func (h *Handler) CalculateTax(c echo.Context) error {
 	var requestBody TaxInfo
 	var err error
 
@@ -30,23 +30,19 @@ func CalculateTax(c echo.Context) error {
 		return c.String(http.StatusBadRequest, err.Error())
 	}
 
-	personalAllowanceAmount, err := getPersonalAllowance()
+	settings, err := h.calculations.LoadSettings(c.Request().Context())
 	if err != nil {
-		return err
-	}
-
-	otherAllowancesAmount, err := getAllowancesAmount(requestBody)
-	if err != nil {
-		return c.String(http.StatusInternalServerError, err.Error())
+		return storeErrorResponse(c, err)
 	}
 
-	allowancesAmount := personalAllowanceAmount + otherAllowancesAmount
+	allowancesAmount := settings.Allowances.Personal + getAllowancesAmount(requestBody, settings.Allowances)
 	tax := calculateTaxByLevels(requestBody.TotalIncome, allowancesAmount)
 	taxLevel := displayTaxLevel(requestBody.TotalIncome, allowancesAmount, tax)
 
 	taxPayable := TaxPayable{
-		Tax:       (math.Round(tax*100) / 100),
-		TaxLevels: taxLevel,
+		Tax:             (math.Round(tax*100) / 100),
+		TaxLevels:       taxLevel,
+		SettingsVersion: settings.Version,
 	}
 
 	taxPayable.Tax = taxPayable.Tax - requestBody.WHT
@@ -57,8 +53,9 @@ func CalculateTax(c echo.Context) error {
 	}
 
 	taxReturnable := TaxReturnable{
-		TaxRefund: math.Round(math.Abs(taxPayable.Tax)*100) / 100,
-		TaxLevels: taxLevel,
+		TaxRefund:       math.Round(math.Abs(taxPayable.Tax)*100) / 100,
+		TaxLevels:       taxLevel,
+		SettingsVersion: settings.Version,
 	}
 
 	return c.JSON(http.StatusOK, taxReturnable)
@@ -122,63 +119,78 @@ func calculateTaxByLevels(totalIncome, allowance float64) float64 {
 	}
 }
 
-func getAllowancesAmount(requestBody TaxInfo) (float64, error) {
+func getAllowancesAmount(requestBody TaxInfo, settings AllowanceSettings) float64 {
 	var allowancesAmount float64
 
+	allowances, _ := applyAllowanceCaps(requestBody, settings)
+	for _, allowance := range allowances {
+		allowancesAmount += allowance.Amount
+	}
+
+	return allowancesAmount
+}
+
+// applyAllowanceCaps returns the allowances of the request as they are
+// deducted, each limited to its cap in settings, and whether any of them
+// was over its cap.
+func applyAllowanceCaps(requestBody TaxInfo, settings AllowanceSettings) (applied []Allowances, capped bool) {
 	for _, allowance := range requestBody.Allowances {
 		allowanceType := strings.ToLower(allowance.AllowanceType)
 
 		if allowanceType == "donation" {
-			donationAllowance, err := getDonationAllowance()
-			if err != nil {
-				return 0, err
-			}
-
-			if allowance.Amount > donationAllowance {
-				allowance.Amount = donationAllowance
+			if allowance.Amount > settings.Donation {
+				allowance.Amount = settings.Donation
+				capped = true
 			}
 
-			allowancesAmount += allowance.Amount
+			applied = append(applied, Allowances{AllowanceType: allowanceType, Amount: allowance.Amount})
 		}
 
 		if allowanceType == "k-receipt" {
-			kReceiptAllowance, err := getKReceiptAllowance()
-			if err != nil {
-				return 0, err
+			if allowance.Amount > settings.KReceipt {
+				allowance.Amount = settings.KReceipt
+				capped = true
 			}
 
-			if allowance.Amount > kReceiptAllowance {
-				allowance.Amount = kReceiptAllowance
-			}
-
-			allowancesAmount += allowance.Amount
+			applied = append(applied, Allowances{AllowanceType: allowanceType, Amount: allowance.Amount})
 		}
 	}
 
-	return allowancesAmount, nil
+	return applied, capped
+}
+
+var taxLevelNames = []string{
+	"0-150,000",
+	"150,001-500,000",
+	"500,001-1,000,000",
+	"1,000,001-2,000,000",
+	"2,000,001 ขึ้นไป",
 }
 
 func displayTaxLevel(totalIncome, allowance, tax float64) []TaxLevel {
-	taxLevels := []TaxLevel{
-		{Level: "0-150,000", Tax: 0.0},
-		{Level: "150,001-500,000", Tax: 0.0},
-		{Level: "500,001-1,000,000", Tax: 0.0},
-		{Level: "1,000,001-2,000,000", Tax: 0.0},
-		{Level: "2,000,001 ขึ้นไป", Tax: 0.0},
+	taxLevels := make([]TaxLevel, len(taxLevelNames))
+	for i, name := range taxLevelNames {
+		taxLevels[i] = TaxLevel{Level: name, Tax: 0.0}
 	}
 
+	taxLevels[taxLevelIndex(totalIncome-allowance)].Tax = tax
+
+	return taxLevels
+}
+
+// taxLevelIndex returns the index in taxLevelNames of the highest level that
+// netIncome reaches.
+func taxLevelIndex(netIncome float64) int {
 	switch {
-	case totalIncome-allowance <= 150000:
-		taxLevels[0].Tax = tax
-	case totalIncome-allowance <= 500000:
-		taxLevels[1].Tax = tax
-	case totalIncome-allowance <= 1000000:
-		taxLevels[2].Tax = tax
-	case totalIncome-allowance <= 2000000:
-		taxLevels[3].Tax = tax
+	case netIncome <= 150000:
+		return 0
+	case netIncome <= 500000:
+		return 1
+	case netIncome <= 1000000:
+		return 2
+	case netIncome <= 2000000:
+		return 3
 	default:
-		taxLevels[4].Tax = tax
+		return 4
 	}
-
-	return taxLevels
 }
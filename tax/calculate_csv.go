@@ -1,20 +1,98 @@
 package tax
 
 import (
+	"context"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
 	"math"
 	"net/http"
+	"path"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
-func CalculateTaxWithCSV(c echo.Context) error {
+const (
+	csvModeStrict = "strict"
+	csvModeReport = "report"
+
+	mimeApplicationNDJSON = "application/x-ndjson"
+	headerSettingsVersion = "X-Settings-Version"
+)
+
+// taxRowReader reads the rows of an upload after its header.
+type taxRowReader interface {
+	Read() ([]string, error)
+	// Line returns the line of the row last read.
+	Line() int
+}
+
+type csvRowReader struct {
+	*csv.Reader
+}
+
+func (r csvRowReader) Line() int {
+	line, _ := r.FieldPos(0)
+	return line
+}
+
+type taxCSVBatch struct {
+	reader   taxRowReader
+	header   []string
+	columns  taxCSVColumns
+	mode     string
+	settings AllowanceSettings
+	detail   bool
+	pool     *rowPool
+}
+
+// taxCSVRow is one row of an upload with either its result or its error.
+type taxCSVRow struct {
+	record []string
+	tax    TaxCSV
+	stats  taxCSVRowStats
+	err    *CSVRowError
+}
+
+// taxCSVRowStats keeps what the batch summary needs from a row beyond its
+// result, tax is before wht.
+type taxCSVRowStats struct {
+	tax    float64
+	level  int
+	capped bool
+}
+
+func (h *Handler) CalculateTaxWithCSV(c echo.Context) error {
+	mode := c.QueryParam("mode")
+	if mode == "" {
+		mode = csvModeStrict
+	}
+	if mode != csvModeStrict && mode != csvModeReport {
+		return c.String(http.StatusBadRequest, "mode should be strict or report")
+	}
+
 	file, err := c.FormFile("taxes.csv")
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "key should be taxes.csv")
+		file, err = c.FormFile("taxes.xlsx")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "key should be taxes.csv or taxes.xlsx")
+	}
+
+	async, err := parseBoolParam(c, "async")
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	detail, err := parseBoolParam(c, "detail")
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
 	}
 
 	src, err := file.Open()
@@ -23,116 +101,303 @@ func CalculateTaxWithCSV(c echo.Context) error {
 	}
 	defer src.Close()
 
+	if async {
+		return h.submitTaxJob(c, src, file.Filename, mode, detail)
+	}
+
+	reader, header, err := openTaxRows(src, file.Filename, c.QueryParam("sheet"))
+	if err != nil {
+		if isXLSXUpload(file.Filename) {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+
+	columns, headerErr := mapTaxCSVHeader(header)
+	if headerErr != nil {
+		return c.JSON(http.StatusBadRequest, headerErr)
+	}
+
+	settings, err := h.calculations.LoadSettings(c.Request().Context())
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	batch := &taxCSVBatch{reader: reader, header: header, columns: columns, mode: mode, settings: settings.Allowances, detail: detail, pool: h.rows}
+
+	switch negotiateTaxCSVFormat(c.Request().Header.Get(echo.HeaderAccept)) {
+	case mimeApplicationNDJSON:
+		return streamTaxCSV(c, batch, settings.Version)
+	case mimeTextCSV:
+		return writeTaxCSVFile(c, batch, settings.Version)
+	case mimeApplicationXLSX:
+		return writeTaxXLSXFile(c, batch, settings.Version)
+	}
+
+	taxCSVResponse, err := collectTaxResponseCSV(c.Request().Context(), batch, settings.Version, nil)
+
+	var rowErr *CSVRowError
+	if errors.As(err, &rowErr) {
+		return c.String(http.StatusBadRequest, rowErr.Reason)
+	}
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, taxCSVResponse)
+}
+
+func parseBoolParam(c echo.Context, name string) (bool, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s should be true or false", name)
+	}
+	return enabled, nil
+}
+
+func isXLSXUpload(filename string) bool {
+	return strings.EqualFold(path.Ext(filename), ".xlsx")
+}
+
+// openTaxRows picks the reader by the extension of the uploaded file and
+// reads the header row.
+func openTaxRows(src io.Reader, filename, sheet string) (taxRowReader, []string, error) {
+	if isXLSXUpload(filename) {
+		return openXLSXRows(src, sheet)
+	}
+
 	reader := csv.NewReader(src)
 
 	header, err := reader.Read()
 	if err != nil {
-		return c.String(http.StatusInternalServerError, err.Error())
+		return nil, nil, err
 	}
+	reader.ReuseRecord = true
 
-	var taxCSV []TaxCSV
+	return csvRowReader{reader}, header, nil
+}
 
-	for {
-		row, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return c.String(http.StatusInternalServerError, err.Error())
-		}
+// collectTaxResponseCSV calculates the whole batch into the JSON response,
+// onRow is called after each row when it is set.
+func collectTaxResponseCSV(ctx context.Context, batch *taxCSVBatch, settingsVersion int64, onRow func(taxCSVRow) error) (TaxResponseCSV, error) {
+	taxCSV := []TaxCSV{}
+	rowErrors := []CSVRowError{}
+	var summary taxCSVSummary
 
-		totalIncome, wht, err := convertIncomeWthRowToFloat64(row)
-		if err != nil {
-			return c.String(http.StatusBadRequest, err.Error())
+	err := batch.each(ctx, func(row taxCSVRow) error {
+		summary.add(row)
+		if row.err != nil {
+			rowErrors = append(rowErrors, *row.err)
+		} else {
+			taxCSV = append(taxCSV, row.tax)
 		}
 
-		allowances, err := getAllowancesListCSV(row, header)
-		if err != nil {
-			return c.String(http.StatusBadRequest, err.Error())
+		if onRow != nil {
+			return onRow(row)
 		}
+		return nil
+	})
+	if err != nil {
+		return TaxResponseCSV{}, err
+	}
+
+	taxCSVResponse := TaxResponseCSV{
+		Taxes:           taxCSV,
+		Summary:         summary.result(),
+		SettingsVersion: settingsVersion,
+	}
+	if batch.mode == csvModeReport {
+		taxCSVResponse.Errors = rowErrors
+	}
+
+	return taxCSVResponse, nil
+}
+
+// streamTaxCSV writes one result per line as soon as its row is calculated,
+// so memory stays bounded by a single row whatever the size of the file. The
+// status is sent before the first row, so in strict mode an invalid row ends
+// the stream with an error line instead of a 400.
+func streamTaxCSV(c echo.Context, batch *taxCSVBatch, settingsVersion int64) error {
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, mimeApplicationNDJSON)
+	response.Header().Set(headerSettingsVersion, strconv.FormatInt(settingsVersion, 10))
+	response.WriteHeader(http.StatusOK)
 
-		taxInfo := TaxInfo{
-			TotalIncome: totalIncome,
-			WHT:         wht,
-			Allowances:  allowances,
+	encoder := json.NewEncoder(response)
+	writeLine := func(line TaxCSVLine) error {
+		if err := encoder.Encode(line); err != nil {
+			return err
 		}
+		response.Flush()
+		return nil
+	}
 
-		if err := checkTaxInfoNotNegative(taxInfo); err != nil {
-			return c.String(http.StatusBadRequest, err.Error())
+	var summary taxCSVSummary
+	err := batch.each(c.Request().Context(), func(row taxCSVRow) error {
+		summary.add(row)
+		if row.err != nil {
+			return writeLine(TaxCSVLine{Error: row.err})
 		}
+		return writeLine(TaxCSVLine{TaxCSV: &row.tax})
+	})
 
-		if err = checkValidTaxAllowances(taxInfo); err != nil {
-			return c.String(http.StatusBadRequest, err.Error())
+	var rowErr *CSVRowError
+	if errors.As(err, &rowErr) {
+		return writeLine(TaxCSVLine{Error: rowErr})
+	}
+	if err != nil {
+		// the client has gone or the upload is unreadable, the status is already sent
+		log.Printf("CSV stream for request %s stopped: %v", getRequestID(c), err)
+		return nil
+	}
+
+	result := summary.result()
+	return writeLine(TaxCSVLine{Summary: &result})
+}
+
+// each calls fn for every row in file order with its result or, in report
+// mode, its error. In strict mode the first invalid row stops the batch and
+// is returned as a *CSVRowError. Rows are read a chunk at a time and the
+// chunk is calculated on the row pool.
+func (b *taxCSVBatch) each(ctx context.Context, fn func(taxCSVRow) error) error {
+	chunk := make([]pendingTaxRow, 0, taxRowChunkSize)
+
+	for {
+		err := ctx.Err()
+		if err == nil {
+			var pending pendingTaxRow
+			if pending, err = b.next(); err == nil {
+				chunk = append(chunk, pending)
+				if len(chunk) < taxRowChunkSize {
+					continue
+				}
+			}
 		}
 
-		personalAllowanceAmount, err := getPersonalAllowance()
-		if err != nil {
-			return c.String(http.StatusInternalServerError, err.Error())
+		if emitErr := b.emit(ctx, chunk, fn); emitErr != nil {
+			return emitErr
 		}
+		chunk = chunk[:0]
 
-		otherAllowancesAmount, err := getAllowancesAmount(taxInfo)
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			return c.String(http.StatusInternalServerError, err.Error())
+			return err
 		}
+	}
+}
 
-		allowancesAmount := personalAllowanceAmount + otherAllowancesAmount
-		tax := calculateTaxByLevels(taxInfo.TotalIncome, allowancesAmount)
+// pendingTaxRow is a row read from the upload but maybe not calculated yet.
+type pendingTaxRow struct {
+	row  taxCSVRow
+	line int
+}
 
-		taxPayable := TaxCSV{
-			TotalIncome: taxInfo.TotalIncome,
-			Tax:         (math.Round(tax*100) / 100),
-		}
+// next reads the next row, in report mode a row the reader cannot split
+// comes back already holding its error.
+func (b *taxCSVBatch) next() (pendingTaxRow, error) {
+	record, err := b.reader.Read()
 
-		taxPayable.Tax = taxPayable.Tax - taxInfo.WHT
-		taxPayable.Tax = math.Round(taxPayable.Tax*100) / 100
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && b.mode == csvModeReport {
+		rowErr := &CSVRowError{Line: parseErr.StartLine, Value: strings.Join(record, ","), Reason: parseErr.Err.Error()}
+		return pendingTaxRow{row: taxCSVRow{record: slices.Clone(record), err: rowErr}}, nil
+	}
+	if err != nil {
+		return pendingTaxRow{}, err
+	}
 
-		if taxPayable.Tax >= 0 {
-			taxCSV = append(taxCSV, taxPayable)
-		} else {
-			taxCSV = append(taxCSV, TaxCSV{
-				TotalIncome: taxInfo.TotalIncome,
-				TaxRefund:   math.Round(math.Abs(taxPayable.Tax)*100) / 100,
-			})
-		}
+	return pendingTaxRow{row: taxCSVRow{record: slices.Clone(record)}, line: b.reader.Line()}, nil
+}
 
+func (b *taxCSVBatch) emit(ctx context.Context, chunk []pendingTaxRow, fn func(taxCSVRow) error) error {
+	err := b.pool.run(ctx, len(chunk), func(i int) {
+		b.calculate(&chunk[i])
+	})
+	if err != nil {
+		return err
 	}
 
-	taxCSVResponse := TaxResponseCSV{
-		Taxes: taxCSV,
+	for _, pending := range chunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if pending.row.err != nil && b.mode == csvModeStrict {
+			return pending.row.err
+		}
+		if err := fn(pending.row); err != nil {
+			return err
+		}
 	}
 
-	return c.JSON(http.StatusOK, taxCSVResponse)
+	return nil
 }
 
-func convertIncomeWthRowToFloat64(row []string) (float64, float64, error) {
-	totalIncomeStr := row[0]
-	totalIncome, err := strconv.ParseFloat(totalIncomeStr, 64)
-	if err != nil {
-		return 0, 0, errors.New("cannot parse str to float64")
+func (b *taxCSVBatch) calculate(pending *pendingTaxRow) {
+	identifiers := b.columns.identifiers(pending.row.record)
+	if pending.row.err != nil {
+		pending.row.err.Identifiers = identifiers
+		return
 	}
 
-	whtStr := row[1]
-	wht, err := strconv.ParseFloat(whtStr, 64)
-	if err != nil {
-		return 0, 0, errors.New("cannot parse str to float64")
+	taxInfo, rowErr := b.columns.parseRow(pending.row.record)
+	if rowErr != nil {
+		rowErr.Line = pending.line
+		rowErr.Identifiers = identifiers
+		pending.row.err = rowErr
+		return
 	}
-	return totalIncome, wht, nil
+
+	pending.row.tax, pending.row.stats = calculateTaxCSVRow(taxInfo, b.settings, b.detail)
+	pending.row.tax.Identifiers = identifiers
 }
 
-func getAllowancesListCSV(row []string, header []string) ([]Allowances, error) {
-	var allowances []Allowances
+func calculateTaxCSVRow(taxInfo TaxInfo, settings AllowanceSettings, detail bool) (TaxCSV, taxCSVRowStats) {
+	allowances, capped := applyAllowanceCaps(taxInfo, settings)
+	allowancesAmount := settings.Personal
+	for _, allowance := range allowances {
+		allowancesAmount += allowance.Amount
+	}
+	tax := math.Round(calculateTaxByLevels(taxInfo.TotalIncome, allowancesAmount)*100) / 100
 
-	for i := 2; i < len(row); i++ {
-		allowanceStr := row[i]
-		allowance, err := strconv.ParseFloat(allowanceStr, 64)
-		if err != nil {
-			return nil, errors.New("cannot parse str to float64")
+	stats := taxCSVRowStats{
+		tax:    tax,
+		level:  taxLevelIndex(taxInfo.TotalIncome - allowancesAmount),
+		capped: capped,
+	}
+
+	taxCSV := TaxCSV{TotalIncome: taxInfo.TotalIncome}
+
+	taxPayable := math.Round((tax-taxInfo.WHT)*100) / 100
+	if taxPayable >= 0 {
+		taxCSV.Tax = taxPayable
+	} else {
+		taxCSV.TaxRefund = math.Round(math.Abs(taxPayable)*100) / 100
+	}
+
+	if detail {
+		taxCSV.Breakdown = &TaxCSVBreakdown{
+			Allowances:    append([]Allowances{{AllowanceType: "personal", Amount: settings.Personal}}, allowances...),
+			NetIncome:     math.Max(0, taxInfo.TotalIncome-allowancesAmount),
+			EffectiveRate: math.Round(effectiveTaxRate(tax, taxInfo.TotalIncome)*100) / 100,
+			TaxLevels:     displayTaxLevel(taxInfo.TotalIncome, allowancesAmount, tax),
 		}
+	}
+
+	return taxCSV, stats
+}
 
-		allowances = append(allowances, Allowances{
-			AllowanceType: header[i],
-			Amount:        allowance,
-		})
+// effectiveTaxRate returns tax as a percentage of totalIncome.
+func effectiveTaxRate(tax, totalIncome float64) float64 {
+	if totalIncome <= 0 {
+		return 0
 	}
-	return allowances, nil
+	return tax / totalIncome * 100
 }
@@ -0,0 +1,276 @@
+package tax
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	mimeTextCSV         = "text/csv"
+	mimeApplicationXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+	xlsxResultsSheet = "Results"
+	xlsxSummarySheet = "Summary"
+)
+
+// negotiateTaxCSVFormat picks the media type of the Accept header with the
+// highest q value that the upload can answer with, the first listed one on a
+// tie, JSON when there is none. A media type with q=0 is not acceptable.
+func negotiateTaxCSVFormat(accept string) string {
+	format, best := echo.MIMEApplicationJSON, 0.0
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+
+		switch mediaType = strings.ToLower(strings.TrimSpace(mediaType)); mediaType {
+		case "*/*":
+			mediaType = echo.MIMEApplicationJSON
+		case echo.MIMEApplicationJSON, mimeApplicationNDJSON, mimeTextCSV, mimeApplicationXLSX:
+		default:
+			continue
+		}
+
+		if q := mediaRangeQuality(params); q > best {
+			format, best = mediaType, q
+		}
+	}
+
+	return format
+}
+
+// mediaRangeQuality reads the q parameter of a media range, 1 when it has
+// none and 0 when it cannot be read.
+func mediaRangeQuality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+
+	return 1
+}
+
+// collectTaxCSVRows keeps every row, a spreadsheet can only be written once
+// the status of the whole file is known.
+func collectTaxCSVRows(ctx context.Context, batch *taxCSVBatch) ([]taxCSVRow, error) {
+	var rows []taxCSVRow
+
+	err := batch.each(ctx, func(row taxCSVRow) error {
+		if len(row.record) != len(batch.header) {
+			row.record = slices.Grow(row.record, len(batch.header))[:len(batch.header)]
+		}
+
+		rows = append(rows, row)
+		return nil
+	})
+
+	return rows, err
+}
+
+// taxCSVDetailHeader names the breakdown columns of a download, in the order
+// of TaxCSVBreakdown.values.
+var taxCSVDetailHeader = []string{
+	"personalAllowance",
+	"donationAllowance",
+	"kReceiptAllowance",
+	"netIncome",
+	"effectiveRate",
+	"taxLevel 0-150,000",
+	"taxLevel 150,001-500,000",
+	"taxLevel 500,001-1,000,000",
+	"taxLevel 1,000,001-2,000,000",
+	"taxLevel 2,000,001 ขึ้นไป",
+}
+
+func taxCSVOutputHeader(batch *taxCSVBatch) []string {
+	header := append(slices.Clone(batch.header), "tax", "taxRefund")
+	if batch.detail {
+		header = append(header, taxCSVDetailHeader...)
+	}
+	if batch.mode == csvModeReport {
+		header = append(header, "error")
+	}
+	return header
+}
+
+func taxCSVErrorResponse(c echo.Context, err error) error {
+	var rowErr *CSVRowError
+	if errors.As(err, &rowErr) {
+		return c.String(http.StatusBadRequest, rowErr.Reason)
+	}
+	return c.String(http.StatusInternalServerError, err.Error())
+}
+
+func setTaxCSVAttachment(c echo.Context, contentType, filename string, settingsVersion int64) {
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	header.Set(headerSettingsVersion, strconv.FormatInt(settingsVersion, 10))
+}
+
+// writeTaxCSVFile answers with the uploaded rows and their tax appended, in
+// report mode an invalid row keeps empty tax columns and the reason.
+func writeTaxCSVFile(c echo.Context, batch *taxCSVBatch, settingsVersion int64) error {
+	rows, err := collectTaxCSVRows(c.Request().Context(), batch)
+	if err != nil {
+		return taxCSVErrorResponse(c, err)
+	}
+
+	setTaxCSVAttachment(c, mimeTextCSV, "taxes.csv", settingsVersion)
+	c.Response().WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(c.Response())
+	if err := writer.Write(taxCSVOutputHeader(batch)); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := row.record
+		for _, cell := range taxCSVOutputCells(batch, row) {
+			switch value := cell.(type) {
+			case float64:
+				record = append(record, formatAmount(value))
+			case string:
+				record = append(record, value)
+			default:
+				record = append(record, "")
+			}
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeTaxXLSXFile answers with a workbook holding the same rows as the CSV
+// download on a results sheet and the totals of the file on a summary sheet.
+func writeTaxXLSXFile(c echo.Context, batch *taxCSVBatch, settingsVersion int64) error {
+	rows, err := collectTaxCSVRows(c.Request().Context(), batch)
+	if err != nil {
+		return taxCSVErrorResponse(c, err)
+	}
+
+	workbook := excelize.NewFile()
+	defer workbook.Close()
+
+	if err := workbook.SetSheetName("Sheet1", xlsxResultsSheet); err != nil {
+		return err
+	}
+
+	results, err := workbook.NewStreamWriter(xlsxResultsSheet)
+	if err != nil {
+		return err
+	}
+
+	if err := setXLSXRow(results, 1, stringsToCells(taxCSVOutputHeader(batch))); err != nil {
+		return err
+	}
+
+	var summary taxCSVSummary
+	for i, row := range rows {
+		cells := make([]any, 0, len(row.record)+3)
+		for j, value := range row.record {
+			cells = append(cells, batch.columns.cellValue(j, value))
+		}
+
+		summary.add(row)
+		cells = append(cells, taxCSVOutputCells(batch, row)...)
+
+		if err := setXLSXRow(results, i+2, cells); err != nil {
+			return err
+		}
+	}
+
+	if err := results.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := workbook.NewSheet(xlsxSummarySheet); err != nil {
+		return err
+	}
+	for i, item := range summary.result().xlsxItems(settingsVersion) {
+		if err := workbook.SetSheetRow(xlsxSummarySheet, fmt.Sprintf("A%d", i+1), &item); err != nil {
+			return err
+		}
+	}
+
+	setTaxCSVAttachment(c, mimeApplicationXLSX, "taxes.xlsx", settingsVersion)
+	c.Response().WriteHeader(http.StatusOK)
+
+	return workbook.Write(c.Response())
+}
+
+// taxCSVOutputCells returns the cells appended to an uploaded row under
+// taxCSVOutputHeader, nil for an empty cell.
+func taxCSVOutputCells(batch *taxCSVBatch, row taxCSVRow) []any {
+	if row.err != nil {
+		cells := make([]any, 2, 3+len(taxCSVDetailHeader))
+		if batch.detail {
+			cells = cells[:2+len(taxCSVDetailHeader)]
+		}
+		return append(cells, row.err.Reason)
+	}
+
+	cells := []any{row.tax.Tax, row.tax.TaxRefund}
+	if batch.detail {
+		for _, value := range row.tax.Breakdown.values() {
+			cells = append(cells, value)
+		}
+	}
+	if batch.mode == csvModeReport {
+		cells = append(cells, nil)
+	}
+
+	return cells
+}
+
+func setXLSXRow(writer *excelize.StreamWriter, row int, cells []any) error {
+	cell, err := excelize.CoordinatesToCellName(1, row)
+	if err != nil {
+		return err
+	}
+	return writer.SetRow(cell, cells)
+}
+
+func stringsToCells(values []string) []any {
+	cells := make([]any, len(values))
+	for i, value := range values {
+		cells[i] = value
+	}
+	return cells
+}
+
+// cellValue writes amounts as numbers so the sheet can sum them, anything
+// else is kept as the text that was uploaded.
+func (columns taxCSVColumns) cellValue(index int, value string) any {
+	if index < len(columns) && columns[index].field != "" {
+		if amount, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return amount
+		}
+	}
+	return value
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
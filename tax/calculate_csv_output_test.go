@@ -0,0 +1,149 @@
+package tax
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestNegotiateTaxCSVFormat(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		expected string
+	}{
+		{"", echo.MIMEApplicationJSON},
+		{"*/*", echo.MIMEApplicationJSON},
+		{"application/json", echo.MIMEApplicationJSON},
+		{"text/csv", mimeTextCSV},
+		{"text/csv; charset=utf-8, application/json;q=0.5", mimeTextCSV},
+		{"text/html, " + mimeApplicationXLSX, mimeApplicationXLSX},
+		{"application/x-ndjson", mimeApplicationNDJSON},
+		{"text/html", echo.MIMEApplicationJSON},
+		{"text/csv;q=0, application/json", echo.MIMEApplicationJSON},
+		{"text/csv;q=0", echo.MIMEApplicationJSON},
+		{"application/json;q=0.5, text/csv", mimeTextCSV},
+		{"text/csv;q=0.8, " + mimeApplicationXLSX + ";q=0.9", mimeApplicationXLSX},
+		{"text/csv;q=0.8, application/x-ndjson;q=0.8", mimeTextCSV},
+		{"text/csv;level=1;Q=0.3, */*;q=0.4", echo.MIMEApplicationJSON},
+		{"text/csv;q=abc, application/x-ndjson", mimeApplicationNDJSON},
+		{"text/csv;q=1.5", echo.MIMEApplicationJSON},
+	}
+
+	for _, tt := range testCases {
+		require.Equal(t, tt.expected, negotiateTaxCSVFormat(tt.accept), tt.accept)
+	}
+}
+
+func TestCalculateTaxWithCSVReturnsCSV(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report", "totalIncome,wht,donation\n500000,0,0\n600000,abc,20000\n600000,40000,20000\n")
+	c.Request().Header.Set(echo.HeaderAccept, "text/csv")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "text/csv", rec.Header().Get(echo.HeaderContentType))
+	require.Equal(t, `attachment; filename="taxes.csv"`, rec.Header().Get(echo.HeaderContentDisposition))
+	require.Equal(t, "totalIncome,wht,donation,tax,taxRefund,error\n"+
+		"500000,0,0,29000,0,\n"+
+		"600000,abc,20000,,,cannot parse str to float64\n"+
+		"600000,40000,20000,0,2000,\n", rec.Body.String())
+}
+
+func TestCalculateTaxWithCSVReturnsCSVWithBreakdown(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report&detail=true", "totalIncome,donation\n500000,0\nabc,0\n")
+	c.Request().Header.Set(echo.HeaderAccept, "text/csv")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "totalIncome,donation,tax,taxRefund,personalAllowance,donationAllowance,kReceiptAllowance,netIncome,effectiveRate,"+
+		`"taxLevel 0-150,000","taxLevel 150,001-500,000","taxLevel 500,001-1,000,000","taxLevel 1,000,001-2,000,000","taxLevel 2,000,001 ขึ้นไป",error`+"\n"+
+		"500000,0,29000,0,60000,0,0,440000,5.8,0,29000,0,0,0,\n"+
+		"abc,0,,,,,,,,,,,,,cannot parse str to float64\n", rec.Body.String())
+}
+
+func TestCalculateTaxWithCSVDownloadRejectsInvalidRowInStrictMode(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSV(t, "totalIncome,wht\n500000,0\n600000,abc\n")
+	c.Request().Header.Set(echo.HeaderAccept, "text/csv")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, "cannot parse str to float64", rec.Body.String())
+}
+
+func TestCalculateTaxWithCSVReturnsXLSX(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSV(t, "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	c.Request().Header.Set(echo.HeaderAccept, mimeApplicationXLSX)
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, mimeApplicationXLSX, rec.Header().Get(echo.HeaderContentType))
+
+	workbook, err := excelize.OpenReader(rec.Body)
+	require.NoError(t, err)
+	defer workbook.Close()
+
+	require.Equal(t, []string{"Results", "Summary"}, workbook.GetSheetList())
+
+	results, err := workbook.GetRows("Results")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"totalIncome", "wht", "donation", "tax", "taxRefund"},
+		{"500000", "0", "0", "29000", "0"},
+		{"600000", "40000", "20000", "0", "2000"},
+		{"750000", "50000", "15000", "11250", "0"},
+	}, results)
+
+	income, err := workbook.GetCellType("Results", "A2")
+	require.NoError(t, err)
+	require.NotEqual(t, excelize.CellTypeSharedString, income)
+
+	summary, err := workbook.GetRows("Summary")
+	require.NoError(t, err)
+	require.Contains(t, summary, []string{"rows", "3"})
+	require.Contains(t, summary, []string{"totalTax", "40250"})
+	require.Contains(t, summary, []string{"totalTaxRefund", "2000"})
+	require.Contains(t, summary, []string{"cappedRows", "0"})
+	require.Contains(t, summary, []string{"taxLevel 500,001-1,000,000 rows", "2"})
+}
+
+func TestCalculateTaxWithCSVDownloadsKeepIdentifiersAsText(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+	content := "employeeId,totalIncome\n0042,500000\n"
+
+	rec, c := mockNewRequestCSV(t, content)
+	c.Request().Header.Set(echo.HeaderAccept, "text/csv")
+
+	require.NoError(t, h.CalculateTaxWithCSV(c))
+	require.Equal(t, "employeeId,totalIncome,tax,taxRefund\n0042,500000,29000,0\n", rec.Body.String())
+
+	rec, c = mockNewRequestCSV(t, content)
+	c.Request().Header.Set(echo.HeaderAccept, mimeApplicationXLSX)
+
+	require.NoError(t, h.CalculateTaxWithCSV(c))
+
+	workbook, err := excelize.OpenReader(rec.Body)
+	require.NoError(t, err)
+	defer workbook.Close()
+
+	employeeID, err := workbook.GetCellValue(xlsxResultsSheet, "A2")
+	require.NoError(t, err)
+	require.Equal(t, "0042", employeeID)
+}
@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// taxCSVSummary adds up the rows of an upload in file order. rows counts the
+// calculated rows only, invalid rows are counted apart.
+type taxCSVSummary struct {
+	rows          int
+	invalidRows   int
+	totalIncome   float64
+	totalTax      float64
+	totalRefund   float64
+	effectiveRate float64
+	levels        []TaxLevelSummary
+	cappedRows    int
+}
+
+func (s *taxCSVSummary) add(row taxCSVRow) {
+	if row.err != nil {
+		s.invalidRows++
+		return
+	}
+
+	s.rows++
+
+	s.totalIncome += row.tax.TotalIncome
+	s.totalTax += row.tax.Tax
+	s.totalRefund += row.tax.TaxRefund
+	s.effectiveRate += effectiveTaxRate(row.stats.tax, row.tax.TotalIncome)
+	s.taxLevels()[row.stats.level].Rows++
+	s.levels[row.stats.level].Tax += row.stats.tax
+	if row.stats.capped {
+		s.cappedRows++
+	}
+}
+
+func (s *taxCSVSummary) result() TaxCSVSummary {
+	summary := TaxCSVSummary{
+		Rows:           s.rows,
+		InvalidRows:    s.invalidRows,
+		TotalIncome:    roundAmount(s.totalIncome),
+		TotalTax:       roundAmount(s.totalTax),
+		TotalTaxRefund: roundAmount(s.totalRefund),
+		TaxLevels:      slices.Clone(s.taxLevels()),
+		CappedRows:     s.cappedRows,
+	}
+
+	if s.rows > 0 {
+		summary.AverageEffectiveRate = roundAmount(s.effectiveRate / float64(s.rows))
+	}
+
+	for i := range summary.TaxLevels {
+		summary.TaxLevels[i].Tax = roundAmount(summary.TaxLevels[i].Tax)
+	}
+
+	return summary
+}
+
+func (s *taxCSVSummary) taxLevels() []TaxLevelSummary {
+	if s.levels == nil {
+		s.levels = make([]TaxLevelSummary, len(taxLevelNames))
+		for i, name := range taxLevelNames {
+			s.levels[i].Level = name
+		}
+	}
+	return s.levels
+}
+
+// xlsxItems lays the summary out as label and value rows for the summary
+// sheet of a workbook.
+func (s TaxCSVSummary) xlsxItems(settingsVersion int64) [][]any {
+	items := [][]any{
+		{"rows", s.Rows},
+		{"invalidRows", s.InvalidRows},
+		{"totalIncome", s.TotalIncome},
+		{"totalTax", s.TotalTax},
+		{"totalTaxRefund", s.TotalTaxRefund},
+		{"averageEffectiveRate", s.AverageEffectiveRate},
+		{"cappedRows", s.CappedRows},
+	}
+
+	for _, level := range s.TaxLevels {
+		items = append(items,
+			[]any{fmt.Sprintf("taxLevel %s rows", level.Level), level.Rows},
+			[]any{fmt.Sprintf("taxLevel %s tax", level.Level), level.Tax},
+		)
+	}
+
+	return append(items, []any{"settingsVersion", settingsVersion})
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
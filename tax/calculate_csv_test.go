@@ -1,69 +1,459 @@
 package tax
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
-	"github.com/stretchr/testify/assert"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
 )
 
-func TestConvertIncomeWthRowToFloat64(t *testing.T) {
-	testCases := []struct {
-		row            []string
-		expectedIncome float64
-		expectedWht    float64
-		expectedError  error
-	}{
-		{[]string{"1000", "200"}, 1000, 200, nil},
-		{[]string{"10.5", "20.75"}, 10.5, 20.75, nil},
-		{[]string{"invalid", "20.75"}, 0, 0, errors.New("cannot parse str to float64")},
-		{[]string{"100.5", "invalid"}, 0, 0, errors.New("cannot parse str to float64")},
-	}
+func TestCalculateTaxWithCSVStrictModeRejectsFile(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
 
-	for _, tt := range testCases {
-		income, wht, err := convertIncomeWthRowToFloat64(tt.row)
-		assert.Equal(t, tt.expectedIncome, income)
-		assert.Equal(t, tt.expectedWht, wht)
-		assert.Equal(t, tt.expectedError, err)
-	}
+	rec, c := mockNewRequestCSV(t, "totalIncome,wht,donation\n500000,0,0\n600000,abc,20000\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, "cannot parse str to float64", rec.Body.String())
+}
+
+func TestCalculateTaxWithCSVReportModeReturnsRowErrors(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report",
+		"totalIncome,wht,donation\n500000,0,0\n600000,abc,20000\n750000,50000\n700000,0,-1\n750000,50000,15000\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, []TaxCSV{
+		{TotalIncome: 500000, Tax: 29000},
+		{TotalIncome: 750000, Tax: 11250},
+	}, responseBody.Taxes)
+	require.Equal(t, []CSVRowError{
+		{Line: 3, Column: "wht", Value: "abc", Reason: "cannot parse str to float64"},
+		{Line: 4, Value: "750000,50000", Reason: "wrong number of fields"},
+		{Line: 5, Column: "donation", Value: "-1", Reason: "allowance amount cannot be less than 0"},
+	}, responseBody.Errors)
+}
+
+func TestCalculateTaxWithCSVEchoesIdentifiers(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report",
+		"employeeId,name,totalIncome,ref_batch\n0001,Somchai,500000,A\n0002,Somsri,500000,A\n0003,Mana,abc,B\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, []TaxCSV{
+		{Identifiers: map[string]string{"employeeId": "0001", "name": "Somchai", "ref_batch": "A"}, TotalIncome: 500000, Tax: 29000},
+		{Identifiers: map[string]string{"employeeId": "0002", "name": "Somsri", "ref_batch": "A"}, TotalIncome: 500000, Tax: 29000},
+	}, responseBody.Taxes)
+	require.Equal(t, []CSVRowError{
+		{Line: 4, Identifiers: map[string]string{"employeeId": "0003", "name": "Mana", "ref_batch": "B"}, Column: "totalIncome", Value: "abc", Reason: "cannot parse str to float64"},
+	}, responseBody.Errors)
 }
 
-func TestGetAllowancesListCSV(t *testing.T) {
-	tests := []struct {
-		row            []string
-		header         []string
-		expectedResult []Allowances
-		expectedError  error
-	}{
-		{
-			[]string{"600000", "40000", "20000"},
-			[]string{"totalIncome", "wht", "donation"},
-			[]Allowances{
-				{AllowanceType: "donation", Amount: 20000},
+func TestCalculateTaxWithCSVReturnsBreakdown(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?detail=true", "totalIncome,wht,donation,k-receipt\n750000,50000,150000,10000\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, []TaxCSV{{
+		TotalIncome: 750000,
+		TaxRefund:   3000,
+		Breakdown: &TaxCSVBreakdown{
+			Allowances: []Allowances{
+				{AllowanceType: "personal", Amount: 60000},
+				{AllowanceType: "donation", Amount: 100000},
+				{AllowanceType: "k-receipt", Amount: 10000},
+			},
+			NetIncome:     580000,
+			EffectiveRate: 6.27,
+			TaxLevels: []TaxLevel{
+				{Level: "0-150,000", Tax: 0},
+				{Level: "150,001-500,000", Tax: 0},
+				{Level: "500,001-1,000,000", Tax: 47000},
+				{Level: "1,000,001-2,000,000", Tax: 0},
+				{Level: "2,000,001 ขึ้นไป", Tax: 0},
 			},
-			nil,
 		},
-		{
-			[]string{"600000", "40000", "invalid"},
-			[]string{"totalIncome", "wht", "donation"},
-			nil,
-			errors.New("cannot parse str to float64"),
+	}}, responseBody.Taxes)
+}
+
+func TestCalculateTaxWithCSVWithInvalidDetail(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?detail=full", "totalIncome\n500000\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, "detail should be true or false", rec.Body.String())
+}
+
+func TestCalculateTaxWithCSVReturnsSummary(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report",
+		"totalIncome,wht,donation,k-receipt\n500000,0,0,0\n600000,40000,20000,0\n750000,50000,150000,10000\nabc,0,0,0\n3000000,0,0,80000\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, TaxCSVSummary{
+		Rows:                 4,
+		InvalidRows:          1,
+		TotalIncome:          4850000,
+		TotalTax:             650500,
+		TotalTaxRefund:       5000,
+		AverageEffectiveRate: 9.78,
+		TaxLevels: []TaxLevelSummary{
+			{Level: "0-150,000", Rows: 0, Tax: 0},
+			{Level: "150,001-500,000", Rows: 1, Tax: 29000},
+			{Level: "500,001-1,000,000", Rows: 2, Tax: 85000},
+			{Level: "1,000,001-2,000,000", Rows: 0, Tax: 0},
+			{Level: "2,000,001 ขึ้นไป", Rows: 1, Tax: 621500},
 		},
-	}
+		CappedRows: 2,
+	}, responseBody.Summary)
+}
+
+func TestCalculateTaxWithCSVSummaryCountsValidRowsApart(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report",
+		"totalIncome,wht\nabc,0\n500000,0\n600000,-1\n,0\n750000,0\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Len(t, responseBody.Taxes, 2)
+	require.Len(t, responseBody.Errors, 3)
+	require.Equal(t, 2, responseBody.Summary.Rows)
+	require.Equal(t, 3, responseBody.Summary.InvalidRows)
+	require.Equal(t, 1250000.0, responseBody.Summary.TotalIncome)
+	require.Equal(t, 92500.0, responseBody.Summary.TotalTax)
+	require.Equal(t, 7.13, responseBody.Summary.AverageEffectiveRate)
+}
+
+func TestCalculateTaxWithCSVDoesNotCountFractionalAllowancesAsCapped(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report",
+		"totalIncome,wht,donation,k-receipt\n500000,0,0.01,1.7\n600000,0,0.1,0.2\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Zero(t, responseBody.Summary.CappedRows)
+}
+
+func TestCalculateTaxWithCSVReportsNonFiniteAmounts(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report",
+		"totalIncome,wht\n500000,0\n100,NaN\nInf,0\n1e400,0\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, []TaxCSV{{TotalIncome: 500000, Tax: 29000}}, responseBody.Taxes)
+	require.Equal(t, []CSVRowError{
+		{Line: 3, Column: "wht", Value: "NaN", Reason: "amount should be a finite number"},
+		{Line: 4, Column: "totalIncome", Value: "Inf", Reason: "amount should be a finite number"},
+		{Line: 5, Column: "totalIncome", Value: "1e400", Reason: "cannot parse str to float64"},
+	}, responseBody.Errors)
+	require.Equal(t, 500000.0, responseBody.Summary.TotalIncome)
+}
+
+func TestCalculateTaxWithCSVMapsColumnsByHeader(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSV(t, "\ufeffK-Receipt,เงินได้รวม,WHT\n15000,750000,50000\n,500000,\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, []TaxCSV{
+		{TotalIncome: 750000, Tax: 11250},
+		{TotalIncome: 500000, Tax: 29000},
+	}, responseBody.Taxes)
+}
+
+func TestCalculateTaxWithCSVRejectsInvalidHeader(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSV(t, "wht,bonus,donation,Donation\n0,1,2,3\n")
+	c.Request().Header.Set(echo.HeaderAccept, "application/x-ndjson")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var responseBody CSVHeaderError
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, CSVHeaderError{
+		Message:          "invalid csv header",
+		UnknownColumns:   []string{"bonus"},
+		MissingColumns:   []string{"totalIncome"},
+		DuplicateColumns: []string{"Donation"},
+	}, responseBody)
+}
 
-	for _, test := range tests {
-		result, err := getAllowancesListCSV(test.row, test.header)
+func TestCalculateTaxWithCSVWithInvalidMode(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
 
-		assert.Equal(t, test.expectedError, err)
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=lenient", "totalIncome,wht\n500000,0\n")
 
-		if test.expectedResult != nil {
-			assert.Len(t, result, len(test.expectedResult))
-			for i := range test.expectedResult {
-				assert.Equal(t, test.expectedResult[i].AllowanceType, result[i].AllowanceType)
-				assert.Equal(t, test.expectedResult[i].Amount, result[i].Amount)
-			}
-		} else {
-			assert.Nil(t, result)
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestCalculateTaxWithCSVUsesOneSettingsSnapshot(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	expectSettingsSnapshot(mock, 4)
+
+	rec, c := mockNewRequestCSV(t, "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, int64(4), responseBody.SettingsVersion)
+	require.Equal(t, []TaxCSV{
+		{TotalIncome: 500000, Tax: 29000},
+		{TotalIncome: 600000, TaxRefund: 2000},
+		{TotalIncome: 750000, Tax: 11250},
+	}, responseBody.Taxes)
+}
+
+func TestCalculateTaxWithCSVLoadsSettingsOncePerFile(t *testing.T) {
+	store := &countingSettingsStore{SettingsStore: NewMemoryStore(DefaultAllowanceSettings)}
+	h := NewHandler(store, nil)
+
+	rec, c := mockNewRequestCSV(t, syntheticTaxesCSV(1000))
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, store.loads)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Len(t, responseBody.Taxes, 1000)
+}
+
+func TestCalculateTaxWithCSVStreamsNDJSON(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?mode=report", "totalIncome,wht,donation\n500000,0,0\n600000,abc,20000\n750000,50000,15000\n")
+	c.Request().Header.Set(echo.HeaderAccept, "application/x-ndjson")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/x-ndjson", rec.Header().Get(echo.HeaderContentType))
+	require.Equal(t, "1", rec.Header().Get("X-Settings-Version"))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, []string{
+		`{"totalIncome":500000,"tax":29000,"taxRefund":0}`,
+		`{"error":{"line":3,"column":"wht","value":"abc","reason":"cannot parse str to float64"}}`,
+		`{"totalIncome":750000,"tax":11250,"taxRefund":0}`,
+	}, lines[:3])
+
+	var summary TaxCSVLine
+	require.NoError(t, json.Unmarshal([]byte(lines[3]), &summary))
+	require.Nil(t, summary.TaxCSV)
+	require.Equal(t, 2, summary.Summary.Rows)
+	require.Equal(t, 1, summary.Summary.InvalidRows)
+	require.Equal(t, 40250.0, summary.Summary.TotalTax)
+}
+
+func TestCalculateTaxWithCSVStreamEchoesIdentifiers(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSV(t, "nationalId,totalIncome\n1101700203451,500000\n")
+	c.Request().Header.Set(echo.HeaderAccept, "application/x-ndjson")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	line, _, _ := strings.Cut(rec.Body.String(), "\n")
+	require.JSONEq(t, `{"identifiers":{"nationalId":"1101700203451"},"totalIncome":500000,"tax":29000,"taxRefund":0}`, line)
+}
+
+func TestCalculateTaxWithCSVStreamStopsAtInvalidRowInStrictMode(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSV(t, "totalIncome,wht,donation\n500000,0,0\n600000,abc,20000\n750000,50000,15000\n")
+	c.Request().Header.Set(echo.HeaderAccept, "application/x-ndjson")
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[1], `"error"`)
+}
+
+func TestCalculateTaxWithCSVStreamStopsWhenClientDisconnects(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSV(t, syntheticTaxesCSV(1000))
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	c.SetRequest(c.Request().WithContext(ctx))
+	c.Request().Header.Set(echo.HeaderAccept, "application/x-ndjson")
+
+	// the client goes away after reading ten results
+	written := 0
+	c.Response().Writer = &cancelAfterWrites{ResponseWriter: rec, writes: &written, limit: 10, cancel: cancel}
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, 10, written)
+}
+
+func BenchmarkCalculateTaxWithCSV(b *testing.B) {
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+	content := syntheticTaxesCSV(50000)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		rec, c := mockNewRequestCSV(b, content)
+		b.StartTimer()
+
+		if err := h.CalculateTaxWithCSV(c); err != nil {
+			b.Fatal(err)
 		}
+		if rec.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d", rec.Code)
+		}
+	}
+}
+
+type cancelAfterWrites struct {
+	http.ResponseWriter
+	writes *int
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (w *cancelAfterWrites) Write(b []byte) (int, error) {
+	*w.writes++
+	if *w.writes == w.limit {
+		w.cancel()
 	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cancelAfterWrites) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+type countingSettingsStore struct {
+	SettingsStore
+	loads int
+}
+
+func (s *countingSettingsStore) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	s.loads++
+	return s.SettingsStore.LoadSettings(ctx)
+}
+
+func syntheticTaxesCSV(rows int) string {
+	var content strings.Builder
+	content.WriteString("totalIncome,wht,donation,k-receipt\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&content, "%d,%d,%d,%d\n", 100000+(i%200)*12500, (i%10)*1000, (i%7)*20000, (i%5)*15000)
+	}
+	return content.String()
+}
+
+func mockNewRequestCSV(t testing.TB, content string) (*httptest.ResponseRecorder, echo.Context) {
+	return mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv", content)
+}
+
+func mockNewRequestCSVWithURL(t testing.TB, url, content string) (*httptest.ResponseRecorder, echo.Context) {
+	return mockNewRequestUpload(t, url, "taxes.csv", []byte(content))
+}
+
+func mockNewRequestUpload(t testing.TB, url, filename string, content []byte) (*httptest.ResponseRecorder, echo.Context) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile(filename, filename)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, url, body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	return rec, e.NewContext(req, rec)
 }
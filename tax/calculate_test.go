@@ -6,23 +6,19 @@ import (
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/require"
 )
 
 func TestCalculateTaxValidRequest(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"donation"}).AddRow(100000)
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"k-receipt"}).AddRow(50000)
-	mock.ExpectQuery(`SELECT "k-receipt" FROM allowances WHERE id = ?`).WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -36,7 +32,7 @@ func TestCalculateTaxValidRequest(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -50,13 +46,9 @@ func TestCalculateTaxValidRequest(t *testing.T) {
 }
 
 func TestCalculateTaxWithWTHReturnTaxRefund(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"donation"}).AddRow(100000)
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -69,7 +61,7 @@ func TestCalculateTaxWithWTHReturnTaxRefund(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -83,13 +75,9 @@ func TestCalculateTaxWithWTHReturnTaxRefund(t *testing.T) {
 }
 
 func TestCalculateTaxRefundWhenHaveWTHButIncomeLessThanCriteria(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"donation"}).AddRow(100000)
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -102,7 +90,7 @@ func TestCalculateTaxRefundWhenHaveWTHButIncomeLessThanCriteria(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -116,13 +104,9 @@ func TestCalculateTaxRefundWhenHaveWTHButIncomeLessThanCriteria(t *testing.T) {
 }
 
 func TestCalculateTaxWithWTHReturnTax(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"donation"}).AddRow(100000)
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -135,7 +119,7 @@ func TestCalculateTaxWithWTHReturnTax(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -149,11 +133,9 @@ func TestCalculateTaxWithWTHReturnTax(t *testing.T) {
 }
 
 func TestCalculateTaxInvalidRequest(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	reqBodyJSON := `{"totalIncome": "not a number"}`
@@ -164,17 +146,15 @@ func TestCalculateTaxInvalidRequest(t *testing.T) {
 	c := e.NewContext(req, rec)
 	require.NotEmpty(t, c)
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.Error(t, errorCalculateTax)
 }
 
 func TestCalculateTaxWithNegativeTotalIncome(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -187,7 +167,7 @@ func TestCalculateTaxWithNegativeTotalIncome(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -196,11 +176,9 @@ func TestCalculateTaxWithNegativeTotalIncome(t *testing.T) {
 }
 
 func TestCalculateTaxWithInvalidAllowanceType(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -213,7 +191,7 @@ func TestCalculateTaxWithInvalidAllowanceType(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -222,11 +200,9 @@ func TestCalculateTaxWithInvalidAllowanceType(t *testing.T) {
 }
 
 func TestCalculateTaxWithAllowanceTypeDuplication(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -240,7 +216,7 @@ func TestCalculateTaxWithAllowanceTypeDuplication(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -248,11 +224,11 @@ func TestCalculateTaxWithAllowanceTypeDuplication(t *testing.T) {
 	require.Equal(t, "found allowanceType duplication", rec.Body.String())
 }
 
-func TestCalculateTaxWithErrorPersonalAllowance(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+func TestCalculateTaxWithErrorSettingsSnapshot(t *testing.T) {
+	h, mock := setupMockHandler()
 
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).
+		WillReturnError(sql.ErrNoRows)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -263,90 +239,62 @@ func TestCalculateTaxWithErrorPersonalAllowance(t *testing.T) {
 		},
 	}
 
-	_, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
-
-	errorCalculateTax := CalculateTax(c)
-
-	require.Error(t, errorCalculateTax)
-}
-
-func TestCalculateTaxWithErrorDonationAllowance(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
-
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnError(sql.ErrNoRows)
-
-	requestBody := TaxInfo{
-		TotalIncome: 1,
-		WHT:         1,
-		Allowances: []Allowances{
-			{AllowanceType: "donation", Amount: 0},
-		},
-	}
+	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	_, err := getAllowancesAmount(requestBody)
+	errorCalculateTax := h.CalculateTax(c)
 
-	require.Error(t, err)
+	require.NoError(t, errorCalculateTax)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, "no record found with the specified id", rec.Body.String())
 }
 
-func TestCalculateTaxWithErrorKReceiptAllowance(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
-
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	mock.ExpectQuery("SELECT k-receipt FROM allowances WHERE id = ?").WithArgs(1).WillReturnError(sql.ErrNoRows)
-
+func TestGetAllowancesAmountCapsDonationAndKReceipt(t *testing.T) {
 	requestBody := TaxInfo{
 		TotalIncome: 1,
 		WHT:         1,
 		Allowances: []Allowances{
-			{AllowanceType: "k-receipt", Amount: 0},
+			{AllowanceType: "donation", Amount: 150000},
+			{AllowanceType: "K-Receipt", Amount: 20000},
 		},
 	}
 
-	_, err := getAllowancesAmount(requestBody)
+	got := getAllowancesAmount(requestBody, AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 10000})
 
-	require.Error(t, err)
+	require.Equal(t, 110000.0, got)
 }
 
-func TestCalculateTaxWithErrorGetAllowancesAmount(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+func TestCalculateTaxReturnsSettingsVersion(t *testing.T) {
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 7)
 
 	e := echo.New()
 	requestBody := TaxInfo{
-		TotalIncome: 2000000,
+		TotalIncome: 500000,
 		WHT:         0.0,
 		Allowances: []Allowances{
-			{AllowanceType: "donation", Amount: 200000},
+			{AllowanceType: "donation", Amount: 0},
+			{AllowanceType: "k-receipt", Amount: 0},
 		},
 	}
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
-	require.NotEmpty(t, rec.Body)
-	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	var responseBody TaxPayable
+	err := json.NewDecoder(rec.Body).Decode(&responseBody)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), responseBody.SettingsVersion)
 }
 
 func TestCalculateTaxWithdonationAmountMoreThanSetting(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"donation"}).AddRow(100000)
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"k-receipt"}).AddRow(50000)
-	mock.ExpectQuery("SELECT k-receipt FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -359,7 +307,7 @@ func TestCalculateTaxWithdonationAmountMoreThanSetting(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -373,13 +321,9 @@ func TestCalculateTaxWithdonationAmountMoreThanSetting(t *testing.T) {
 }
 
 func TestCalculateTaxWithKReceiptAmountMoreThanSetting(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+	h, mock := setupMockHandler()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
-	rows = mock.NewRows([]string{"k-receipt"}).AddRow(50000)
-	mock.ExpectQuery(`SELECT "k-receipt" FROM allowances WHERE id = ?`).WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 1)
 
 	e := echo.New()
 	requestBody := TaxInfo{
@@ -392,7 +336,7 @@ func TestCalculateTaxWithKReceiptAmountMoreThanSetting(t *testing.T) {
 
 	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
 
-	errorCalculateTax := CalculateTax(c)
+	errorCalculateTax := h.CalculateTax(c)
 
 	require.NoError(t, errorCalculateTax)
 	require.NotEmpty(t, rec.Body)
@@ -513,3 +457,42 @@ func mockNewRequest(requestBody TaxInfo, t *testing.T, e *echo.Echo, url string)
 	require.NotEmpty(t, c)
 	return rec, c
 }
+
+func expectSettingsSnapshot(mock sqlmock.Sqlmock, version int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).
+		WillReturnRows(settingsSnapshotRows(mock, version, DefaultAllowanceSettings))
+}
+
+func TestApplyAllowanceCaps(t *testing.T) {
+	requestBody := TaxInfo{
+		Allowances: []Allowances{
+			{AllowanceType: "Donation", Amount: 200000},
+			{AllowanceType: "k-receipt", Amount: 5000},
+		},
+	}
+
+	got, capped := applyAllowanceCaps(requestBody, AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 50000})
+
+	require.Equal(t, []Allowances{
+		{AllowanceType: "donation", Amount: 100000},
+		{AllowanceType: "k-receipt", Amount: 5000},
+	}, got)
+	require.True(t, capped)
+}
+
+func TestApplyAllowanceCapsWithFractionalAmounts(t *testing.T) {
+	requestBody := TaxInfo{
+		Allowances: []Allowances{
+			{AllowanceType: "donation", Amount: 0.01},
+			{AllowanceType: "k-receipt", Amount: 1.7},
+		},
+	}
+
+	got, capped := applyAllowanceCaps(requestBody, DefaultAllowanceSettings)
+
+	require.Equal(t, []Allowances{
+		{AllowanceType: "donation", Amount: 0.01},
+		{AllowanceType: "k-receipt", Amount: 1.7},
+	}, got)
+	require.False(t, capped)
+}
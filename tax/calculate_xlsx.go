@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxRowReader reads the rows of a sheet as text, the same way a CSV export
+// of it would look, so both uploads share the header mapping and validation.
+type xlsxRowReader struct {
+	rows    [][]string
+	columns int
+	next    int
+}
+
+// openXLSXRows reads the sheet selected by name or by its 1-based position,
+// the first sheet when sheet is empty, and returns its first row as the
+// header.
+func openXLSXRows(src io.Reader, sheet string) (*xlsxRowReader, []string, error) {
+	workbook, err := excelize.OpenReader(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read xlsx file: %w", err)
+	}
+	defer workbook.Close()
+
+	name, err := selectXLSXSheet(workbook.GetSheetList(), sheet)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// raw values keep numbers free of display formats such as thousands
+	// separators, and text cells keep their leading zeros
+	rows, err := workbook.GetRows(name, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("sheet %s is empty", name)
+	}
+
+	return &xlsxRowReader{rows: rows, columns: len(rows[0]), next: 1}, rows[0], nil
+}
+
+func selectXLSXSheet(sheets []string, sheet string) (string, error) {
+	if len(sheets) == 0 {
+		return "", errors.New("workbook has no sheets")
+	}
+
+	if sheet == "" {
+		return sheets[0], nil
+	}
+
+	for _, name := range sheets {
+		if strings.EqualFold(name, sheet) {
+			return name, nil
+		}
+	}
+
+	if index, err := strconv.Atoi(sheet); err == nil && index >= 1 && index <= len(sheets) {
+		return sheets[index-1], nil
+	}
+
+	return "", fmt.Errorf("sheet %s not found", sheet)
+}
+
+func (r *xlsxRowReader) Read() ([]string, error) {
+	for r.next < len(r.rows) {
+		row := r.rows[r.next]
+		r.next++
+
+		// blank rows are skipped like blank lines of a CSV file
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+
+		// a sheet leaves out empty cells at the end of a row
+		if len(row) < r.columns {
+			row = append(row, make([]string, r.columns-len(row))...)
+		}
+		if len(row) > r.columns {
+			return row, &csv.ParseError{StartLine: r.next, Line: r.next, Column: r.columns + 1, Err: csv.ErrFieldCount}
+		}
+
+		return row, nil
+	}
+
+	return nil, io.EOF
+}
+
+func (r *xlsxRowReader) Line() int {
+	return r.next
+}
@@ -0,0 +1,190 @@
+package tax
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+)
+
+func newTaxesXLSX(t *testing.T) []byte {
+	workbook := excelize.NewFile()
+	defer workbook.Close()
+
+	require.NoError(t, workbook.SetSheetName("Sheet1", "Notes"))
+	require.NoError(t, workbook.SetCellValue("Notes", "A1", "exported from HR"))
+
+	_, err := workbook.NewSheet("Payroll")
+	require.NoError(t, err)
+	require.NoError(t, workbook.SetSheetRow("Payroll", "A1", &[]any{"เงินได้รวม", "wht", "donation"}))
+	require.NoError(t, workbook.SetSheetRow("Payroll", "A2", &[]any{500000, 0, 0}))
+	require.NoError(t, workbook.SetSheetRow("Payroll", "A4", &[]any{600000, 40000, 20000}))
+	require.NoError(t, workbook.SetSheetRow("Payroll", "A5", &[]any{750000, 50000}))
+
+	// a display format must not leak into the uploaded value
+	style, err := workbook.NewStyle(&excelize.Style{NumFmt: 3})
+	require.NoError(t, err)
+	require.NoError(t, workbook.SetCellStyle("Payroll", "A2", "A5", style))
+
+	var content bytes.Buffer
+	require.NoError(t, workbook.Write(&content))
+	return content.Bytes()
+}
+
+func TestCalculateTaxWithXLSX(t *testing.T) {
+	for _, sheet := range []string{"Payroll", "payroll", "2"} {
+		h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+		rec, c := mockNewRequestUpload(t, "/tax/calculations/upload-csv?sheet="+sheet, "taxes.xlsx", newTaxesXLSX(t))
+
+		err := h.CalculateTaxWithCSV(c)
+
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var responseBody TaxResponseCSV
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+		require.Equal(t, []TaxCSV{
+			{TotalIncome: 500000, Tax: 29000},
+			{TotalIncome: 600000, TaxRefund: 2000},
+			{TotalIncome: 750000, Tax: 13500},
+		}, responseBody.Taxes)
+	}
+}
+
+func TestCalculateTaxWithXLSXReportsRowLines(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	workbook := excelize.NewFile()
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A1", &[]any{"totalIncome", "wht"}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A2", &[]any{500000, 0}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A3", &[]any{"abc", 0}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A4", &[]any{500000, 0, 1}))
+	var content bytes.Buffer
+	require.NoError(t, workbook.Write(&content))
+
+	rec, c := mockNewRequestUpload(t, "/tax/calculations/upload-csv?mode=report", "taxes.xlsx", content.Bytes())
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Len(t, responseBody.Taxes, 1)
+	require.Equal(t, []CSVRowError{
+		{Line: 3, Column: "totalIncome", Value: "abc", Reason: "cannot parse str to float64"},
+		{Line: 4, Value: "500000,0,1", Reason: "wrong number of fields"},
+	}, responseBody.Errors)
+}
+
+func TestCalculateTaxWithXLSXReportsNonFiniteAmounts(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	workbook := excelize.NewFile()
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A1", &[]any{"totalIncome", "wht"}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A2", &[]any{500000, 0}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A3", &[]any{100, "NaN"}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A4", &[]any{"+Inf", 0}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A5", &[]any{"1e400", 0}))
+	var content bytes.Buffer
+	require.NoError(t, workbook.Write(&content))
+
+	rec, c := mockNewRequestUpload(t, "/tax/calculations/upload-csv?mode=report", "taxes.xlsx", content.Bytes())
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var responseBody TaxResponseCSV
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Len(t, responseBody.Taxes, 1)
+	require.Equal(t, []CSVRowError{
+		{Line: 3, Column: "wht", Value: "NaN", Reason: "amount should be a finite number"},
+		{Line: 4, Column: "totalIncome", Value: "+Inf", Reason: "amount should be a finite number"},
+		{Line: 5, Column: "totalIncome", Value: "1e400", Reason: "cannot parse str to float64"},
+	}, responseBody.Errors)
+}
+
+func TestCalculateTaxWithXLSXWithUnknownSheet(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestUpload(t, "/tax/calculations/upload-csv?sheet=3", "taxes.xlsx", newTaxesXLSX(t))
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, "sheet 3 not found", rec.Body.String())
+}
+
+func TestCalculateTaxWithXLSXWithoutSheets(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestUpload(t, "/tax/calculations/upload-csv", "taxes.xlsx", newXLSXWithoutSheets(t))
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.Equal(t, "workbook has no sheets", rec.Body.String())
+}
+
+func TestSelectXLSXSheetWithoutSheets(t *testing.T) {
+	_, err := selectXLSXSheet(nil, "")
+
+	require.EqualError(t, err, "workbook has no sheets")
+}
+
+// newXLSXWithoutSheets returns a workbook whose sheet list is empty, which
+// Excel does not save but a hand made file can have.
+func newXLSXWithoutSheets(t *testing.T) []byte {
+	var content bytes.Buffer
+	workbook := excelize.NewFile()
+	require.NoError(t, workbook.Write(&content))
+	require.NoError(t, workbook.Close())
+
+	source, err := zip.NewReader(bytes.NewReader(content.Bytes()), int64(content.Len()))
+	require.NoError(t, err)
+
+	var rewritten bytes.Buffer
+	target := zip.NewWriter(&rewritten)
+	for _, file := range source.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+
+		if file.Name == "xl/workbook.xml" {
+			data = regexp.MustCompile(`<sheets>.*</sheets>`).ReplaceAll(data, []byte("<sheets></sheets>"))
+		}
+
+		w, err := target.Create(file.Name)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, target.Close())
+
+	return rewritten.Bytes()
+}
+
+func TestCalculateTaxWithInvalidXLSX(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestUpload(t, "/tax/calculations/upload-csv", "taxes.xlsx", []byte("totalIncome,wht\n"))
+
+	err := h.CalculateTaxWithCSV(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,196 @@
+package tax
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	csvFieldTotalIncome = "totalIncome"
+	csvFieldWHT         = "wht"
+
+	csvIdentifierRefPrefix = "ref_"
+)
+
+// csvColumnAliases maps a lower-cased header to the field it fills, allowance
+// columns map to their allowance type.
+var csvColumnAliases = map[string]string{
+	"totalincome":  csvFieldTotalIncome,
+	"total_income": csvFieldTotalIncome,
+	"total income": csvFieldTotalIncome,
+	"income":       csvFieldTotalIncome,
+	"เงินได้รวม":   csvFieldTotalIncome,
+	"รายได้รวม":    csvFieldTotalIncome,
+
+	"wht":             csvFieldWHT,
+	"withholding":     csvFieldWHT,
+	"withholding_tax": csvFieldWHT,
+	"withholding tax": csvFieldWHT,
+	"ภาษีหัก ณ ที่จ่าย": csvFieldWHT,
+
+	"donation":   "donation",
+	"เงินบริจาค": "donation",
+
+	"k-receipt":  "k-receipt",
+	"k_receipt":  "k-receipt",
+	"kreceipt":   "k-receipt",
+	"k receipt":  "k-receipt",
+	"ช้อปลดภาษี": "k-receipt",
+	"โครงการช้อปลดภาษี": "k-receipt",
+}
+
+// csvIdentifierAliases maps a lower-cased header to the key its value is
+// echoed under in every result, any header starting with ref_ is echoed under
+// its own name.
+var csvIdentifierAliases = map[string]string{
+	"employeeid":  "employeeId",
+	"employee_id": "employeeId",
+	"employee id": "employeeId",
+	"รหัสพนักงาน": "employeeId",
+
+	"nationalid":  "nationalId",
+	"national_id": "nationalId",
+	"national id": "nationalId",
+	"เลขประจำตัวประชาชน": "nationalId",
+
+	"name": "name",
+	"ชื่อ": "name",
+}
+
+var requiredCSVFields = []string{csvFieldTotalIncome}
+
+type csvColumn struct {
+	header     string
+	field      string
+	identifier string
+}
+
+// taxCSVColumns holds the field or identifier of every column of an upload in
+// file order.
+type taxCSVColumns []csvColumn
+
+// mapTaxCSVHeader matches every header to a field by name, so columns may
+// come in any order and optional ones may be left out. All problems with the
+// header are reported together before any row is read.
+func mapTaxCSVHeader(header []string) (taxCSVColumns, *CSVHeaderError) {
+	columns := make(taxCSVColumns, len(header))
+	headerErr := &CSVHeaderError{Message: "invalid csv header"}
+	seen := map[string]bool{}
+
+	for i, name := range header {
+		if i == 0 {
+			// spreadsheet exports often start with a byte order mark
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+
+		column, ok := lookupCSVColumn(name)
+		if !ok {
+			headerErr.UnknownColumns = append(headerErr.UnknownColumns, name)
+			continue
+		}
+
+		key := column.field + column.identifier
+		if seen[key] {
+			headerErr.DuplicateColumns = append(headerErr.DuplicateColumns, name)
+			continue
+		}
+		seen[key] = true
+
+		columns[i] = column
+	}
+
+	for _, field := range requiredCSVFields {
+		if !seen[field] {
+			headerErr.MissingColumns = append(headerErr.MissingColumns, field)
+		}
+	}
+
+	if len(headerErr.UnknownColumns) > 0 || len(headerErr.MissingColumns) > 0 || len(headerErr.DuplicateColumns) > 0 {
+		return nil, headerErr
+	}
+
+	return columns, nil
+}
+
+func lookupCSVColumn(name string) (csvColumn, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+
+	if field, ok := csvColumnAliases[key]; ok {
+		return csvColumn{header: name, field: field}, true
+	}
+	if identifier, ok := csvIdentifierAliases[key]; ok {
+		return csvColumn{header: name, identifier: identifier}, true
+	}
+	if strings.HasPrefix(key, csvIdentifierRefPrefix) && len(key) > len(csvIdentifierRefPrefix) {
+		return csvColumn{header: name, identifier: strings.TrimSpace(name)}, true
+	}
+
+	return csvColumn{}, false
+}
+
+// identifiers returns the identifier cells of a row as uploaded, nil when the
+// upload has no identifier columns.
+func (columns taxCSVColumns) identifiers(row []string) map[string]string {
+	var identifiers map[string]string
+
+	for i, column := range columns {
+		if column.identifier == "" || i >= len(row) {
+			continue
+		}
+		if identifiers == nil {
+			identifiers = map[string]string{}
+		}
+		identifiers[column.identifier] = row[i]
+	}
+
+	return identifiers
+}
+
+// parseRow reads a row by the field of each column. An empty cell of an
+// optional column counts as 0.
+func (columns taxCSVColumns) parseRow(row []string) (TaxInfo, *CSVRowError) {
+	var taxInfo TaxInfo
+
+	for i, column := range columns {
+		if column.identifier != "" {
+			continue
+		}
+
+		value := strings.TrimSpace(row[i])
+		if value == "" && column.field != csvFieldTotalIncome {
+			continue
+		}
+
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return TaxInfo{}, &CSVRowError{Column: column.header, Value: row[i], Reason: "cannot parse str to float64"}
+		}
+
+		if math.IsNaN(amount) || math.IsInf(amount, 0) {
+			return TaxInfo{}, &CSVRowError{Column: column.header, Value: row[i], Reason: "amount should be a finite number"}
+		}
+
+		switch column.field {
+		case csvFieldTotalIncome:
+			taxInfo.TotalIncome = amount
+		case csvFieldWHT:
+			taxInfo.WHT = amount
+		default:
+			taxInfo.Allowances = append(taxInfo.Allowances, Allowances{
+				AllowanceType: column.field,
+				Amount:        amount,
+			})
+		}
+
+		if err := checkTaxInfoNotNegative(taxInfo); err != nil {
+			return TaxInfo{}, &CSVRowError{Column: column.header, Value: row[i], Reason: err.Error()}
+		}
+
+		if err := checkValidTaxAllowances(taxInfo); err != nil {
+			return TaxInfo{}, &CSVRowError{Column: column.header, Value: row[i], Reason: err.Error()}
+		}
+	}
+
+	return taxInfo, nil
+}
@@ -0,0 +1,100 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapTaxCSVHeader(t *testing.T) {
+	columns, err := mapTaxCSVHeader([]string{"\ufeffWHT", " Total Income ", "เงินบริจาค", "k_receipt"})
+
+	require.Nil(t, err)
+	require.Equal(t, taxCSVColumns{
+		{header: "WHT", field: csvFieldWHT},
+		{header: " Total Income ", field: csvFieldTotalIncome},
+		{header: "เงินบริจาค", field: "donation"},
+		{header: "k_receipt", field: "k-receipt"},
+	}, columns)
+}
+
+func TestMapTaxCSVHeaderWithOnlyRequiredColumns(t *testing.T) {
+	columns, err := mapTaxCSVHeader([]string{"เงินได้รวม"})
+
+	require.Nil(t, err)
+	require.Equal(t, taxCSVColumns{{header: "เงินได้รวม", field: csvFieldTotalIncome}}, columns)
+}
+
+func TestMapTaxCSVHeaderWithIdentifierColumns(t *testing.T) {
+	columns, err := mapTaxCSVHeader([]string{"Employee ID", "totalIncome", "เลขประจำตัวประชาชน", "Name", "ref_Cost_Center"})
+
+	require.Nil(t, err)
+	require.Equal(t, taxCSVColumns{
+		{header: "Employee ID", identifier: "employeeId"},
+		{header: "totalIncome", field: csvFieldTotalIncome},
+		{header: "เลขประจำตัวประชาชน", identifier: "nationalId"},
+		{header: "Name", identifier: "name"},
+		{header: "ref_Cost_Center", identifier: "ref_Cost_Center"},
+	}, columns)
+
+	require.Equal(t, map[string]string{
+		"employeeId":      "0042",
+		"nationalId":      "1101700203451",
+		"name":            " Somchai ",
+		"ref_Cost_Center": "",
+	}, columns.identifiers([]string{"0042", "500000", "1101700203451", " Somchai ", ""}))
+}
+
+func TestMapTaxCSVHeaderRejectsDuplicateIdentifier(t *testing.T) {
+	_, err := mapTaxCSVHeader([]string{"totalIncome", "employee_id", "EmployeeID", "ref_"})
+
+	require.Equal(t, &CSVHeaderError{
+		Message:          "invalid csv header",
+		UnknownColumns:   []string{"ref_"},
+		DuplicateColumns: []string{"EmployeeID"},
+	}, err)
+}
+
+func TestMapTaxCSVHeaderReportsEveryProblem(t *testing.T) {
+	_, err := mapTaxCSVHeader([]string{"wht", "withholding", "bonus", "salary"})
+
+	require.Equal(t, &CSVHeaderError{
+		Message:          "invalid csv header",
+		UnknownColumns:   []string{"bonus", "salary"},
+		MissingColumns:   []string{csvFieldTotalIncome},
+		DuplicateColumns: []string{"withholding"},
+	}, err)
+}
+
+func TestParseTaxCSVRow(t *testing.T) {
+	columns, headerErr := mapTaxCSVHeader([]string{"totalIncome", "wht", "donation"})
+	require.Nil(t, headerErr)
+
+	testCases := []struct {
+		row           []string
+		expected      TaxInfo
+		expectedError *CSVRowError
+	}{
+		{[]string{"1000", "200", "0"}, TaxInfo{TotalIncome: 1000, WHT: 200, Allowances: []Allowances{{AllowanceType: "donation", Amount: 0}}}, nil},
+		{[]string{"600000", "40000", "20000"}, TaxInfo{TotalIncome: 600000, WHT: 40000, Allowances: []Allowances{{AllowanceType: "donation", Amount: 20000}}}, nil},
+		{[]string{" 600000 ", "", ""}, TaxInfo{TotalIncome: 600000}, nil},
+		{[]string{"", "0", "0"}, TaxInfo{}, &CSVRowError{Column: "totalIncome", Value: "", Reason: "cannot parse str to float64"}},
+		{[]string{"invalid", "20.75", "0"}, TaxInfo{}, &CSVRowError{Column: "totalIncome", Value: "invalid", Reason: "cannot parse str to float64"}},
+		{[]string{"100.5", "invalid", "0"}, TaxInfo{}, &CSVRowError{Column: "wht", Value: "invalid", Reason: "cannot parse str to float64"}},
+		{[]string{"600000", "40000", "invalid"}, TaxInfo{}, &CSVRowError{Column: "donation", Value: "invalid", Reason: "cannot parse str to float64"}},
+		{[]string{"600000", "-1", "0"}, TaxInfo{}, &CSVRowError{Column: "wht", Value: "-1", Reason: "total income and wht cannot be less than 0"}},
+		{[]string{"600000", "0", "-5"}, TaxInfo{}, &CSVRowError{Column: "donation", Value: "-5", Reason: "allowance amount cannot be less than 0"}},
+		{[]string{"100", "NaN", "0"}, TaxInfo{}, &CSVRowError{Column: "wht", Value: "NaN", Reason: "amount should be a finite number"}},
+		{[]string{"Inf", "0", "0"}, TaxInfo{}, &CSVRowError{Column: "totalIncome", Value: "Inf", Reason: "amount should be a finite number"}},
+		{[]string{"600000", "0", "-inf"}, TaxInfo{}, &CSVRowError{Column: "donation", Value: "-inf", Reason: "amount should be a finite number"}},
+		{[]string{"1e400", "0", "0"}, TaxInfo{}, &CSVRowError{Column: "totalIncome", Value: "1e400", Reason: "cannot parse str to float64"}},
+	}
+
+	for _, tt := range testCases {
+		taxInfo, err := columns.parseRow(tt.row)
+
+		assert.Equal(t, tt.expectedError, err, tt.row)
+		assert.Equal(t, tt.expected, taxInfo, tt.row)
+	}
+}
@@ -1,49 +1,166 @@
 package tax
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"log"
-	"os"
+	"time"
 
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
-var conn *sql.DB
+const (
+	connectAttempts       = 6
+	connectInitialBackoff = 500 * time.Millisecond
+	connectMaxBackoff     = 8 * time.Second
+	connectPingTimeout    = 5 * time.Second
+)
 
-func ConnectDb() {
-	var err error
+const selectCurrentAllowanceCaps = `SELECT tax_years.version, allowance_caps.deduction_type, allowance_caps.amount FROM tax_years JOIN allowance_caps ON allowance_caps.version_id = tax_years.version WHERE tax_years.is_current`
 
-	conn, err = sql.Open("postgres", os.Getenv("DATABASE_URL"))
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+type SQLStore struct {
+	db           *sql.DB
+	dialect      Dialect
+	queryTimeout time.Duration
+}
+
+func ConnectDb(config DBConfig) *sql.DB {
+	conn, err := sql.Open(config.Dialect.driverName(), config.dataSourceName())
 
 	if err != nil {
 		log.Fatal("Cannot connect to database.", err)
 	}
+
+	config.apply(conn)
+
+	if err := pingWithRetry(context.Background(), conn, connectAttempts, connectInitialBackoff); err != nil {
+		log.Fatal("Cannot connect to database.", err)
+	}
+
+	return conn
 }
 
-func getPersonalAllowance() (float64, error) {
-	var personalAllowance float64
-	err := conn.QueryRow("SELECT personal FROM allowances WHERE id = $1", 1).Scan(&personalAllowance)
-	if err != nil {
-		return 0, errors.New("no record found with the specified id")
+func pingWithRetry(ctx context.Context, db pinger, attempts int, backoff time.Duration) error {
+	var err error
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, connectPingTimeout)
+		err = db.PingContext(pingCtx)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt == attempts {
+			break
+		}
+
+		log.Printf("Database is not reachable (attempt %d/%d), retrying in %s: %v", attempt, attempts, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, connectMaxBackoff)
+	}
+
+	return err
+}
+
+func NewSQLStore(db *sql.DB, config DBConfig) *SQLStore {
+	return &SQLStore{db: db, dialect: config.Dialect, queryTimeout: config.QueryTimeout}
+}
+
+func NewPostgresStore(db *sql.DB, queryTimeout time.Duration) *SQLStore {
+	return &SQLStore{db: db, dialect: DialectPostgres, queryTimeout: queryTimeout}
+}
+
+func NewSQLiteStore(db *sql.DB, queryTimeout time.Duration) *SQLStore {
+	return &SQLStore{db: db, dialect: DialectSQLite, queryTimeout: queryTimeout}
+}
+
+func (d Dialect) driverName() string {
+	if d == DialectSQLite {
+		return "sqlite"
+	}
+	return "postgres"
+}
+
+// forUpdate locks the selected rows on Postgres, SQLite runs one
+// transaction at a time so it needs no row locks.
+func (d Dialect) forUpdate() string {
+	if d == DialectSQLite {
+		return ""
 	}
-	return personalAllowance, nil
+	return " FOR UPDATE"
 }
 
-func getDonationAllowance() (float64, error) {
-	var donationAllowance float64
-	err := conn.QueryRow("SELECT donation FROM allowances WHERE id = $1", 1).Scan(&donationAllowance)
+func (s *SQLStore) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+func (s *SQLStore) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, selectCurrentAllowanceCaps)
 	if err != nil {
-		return 0, errors.New("no record found with the specified id")
+		return SettingsSnapshot{}, classifyQueryError(ctx, err)
 	}
-	return donationAllowance, nil
+	defer rows.Close()
+
+	var snapshot SettingsSnapshot
+	found := false
+
+	for rows.Next() {
+		var allowanceType string
+		var amount float64
+
+		if err := rows.Scan(&snapshot.Version, &allowanceType, &amount); err != nil {
+			return SettingsSnapshot{}, classifyQueryError(ctx, err)
+		}
+
+		snapshot.Allowances.set(allowanceType, amount)
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return SettingsSnapshot{}, classifyQueryError(ctx, err)
+	}
+
+	if !found {
+		return SettingsSnapshot{}, classifyStoreError(sql.ErrNoRows)
+	}
+
+	return snapshot, nil
 }
 
-func getKReceiptAllowance() (float64, error) {
-	var kReceiptAllowance float64
-	err := conn.QueryRow(`SELECT "k-receipt" FROM allowances WHERE id = $1`, 1).Scan(&kReceiptAllowance)
+func (s *SQLStore) DeductionLimit(ctx context.Context, allowanceType string) (DeductionLimit, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var limit DeductionLimit
+	err := s.db.QueryRowContext(ctx, `SELECT min_amount, max_amount FROM deduction_types WHERE code = $1`, allowanceType).Scan(&limit.Min, &limit.Max)
 	if err != nil {
-		return 0, errors.New("no record found with the specified id")
+		return DeductionLimit{}, classifyQueryError(ctx, err)
 	}
-	return kReceiptAllowance, nil
+
+	return limit, nil
+}
+
+func (s *SQLStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	return classifyQueryError(ctx, s.db.PingContext(ctx))
 }
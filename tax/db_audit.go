@@ -0,0 +1,105 @@
+package tax
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+const selectAuditEntryColumns = `SELECT id, occurred_at, username, authenticated, source_ip, request_id, method, endpoint, payload, status FROM admin_audit_log`
+
+func (s *SQLStore) AppendAuditEntry(ctx context.Context, entry AuditEntry) error {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO admin_audit_log (username, authenticated, source_ip, request_id, method, endpoint, payload, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		entry.Username, entry.Authenticated, entry.SourceIP, entry.RequestID, entry.Method, entry.Endpoint, entry.Payload, entry.Status)
+	return err
+}
+
+// ScanAuditEntries is bounded by the request context only, since an export
+// streams rows for longer than a single query timeout.
+func (s *SQLStore) ScanAuditEntries(ctx context.Context, filter AuditFilter, fn func(AuditEntry) error) error {
+	query, args := buildAuditQuery(filter)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(
+			&entry.ID,
+			&entry.OccurredAt,
+			&entry.Username,
+			&entry.Authenticated,
+			&entry.SourceIP,
+			&entry.RequestID,
+			&entry.Method,
+			&entry.Endpoint,
+			&entry.Payload,
+			&entry.Status,
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// escapeLike makes % and _ in value match themselves in a LIKE pattern with
+// ESCAPE '\'.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+func buildAuditQuery(filter AuditFilter) (string, []any) {
+	var conditions []string
+	var args []any
+
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+
+	if filter.Username != "" {
+		addCondition("username = ?", filter.Username)
+	}
+	if filter.Endpoint != "" {
+		addCondition(`endpoint LIKE ? ESCAPE '\'`, escapeLike(filter.Endpoint)+"%")
+	}
+	if filter.Status != 0 {
+		addCondition("status = ?", filter.Status)
+	}
+	// occurred_at is stored in UTC, and SQLite compares timestamps as text
+	if !filter.From.IsZero() {
+		addCondition("occurred_at >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		addCondition("occurred_at < ?", filter.To.UTC())
+	}
+
+	query := selectAuditEntryColumns
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	if filter.Offset > 0 {
+		args = append(args, filter.Offset)
+		query += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	return query, args
+}
@@ -0,0 +1,87 @@
+package tax
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var auditEntryColumns = []string{"id", "occurred_at", "username", "authenticated", "source_ip", "request_id", "method", "endpoint", "payload", "status"}
+
+func TestInsertAuditEntry(t *testing.T) {
+	store, mock := setupMockStore()
+
+	entry := AuditEntry{
+		Username:      "adminTax",
+		Authenticated: true,
+		SourceIP:      "10.0.0.1",
+		RequestID:     "req-1",
+		Method:        "POST",
+		Endpoint:      "/admin/deductions/personal",
+		Payload:       `{"amount":70000}`,
+		Status:        202,
+	}
+	expectAuditEntryInserted(mock, entry)
+
+	err := store.AppendAuditEntry(context.Background(), entry)
+
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBuildAuditQueryWithoutFilter(t *testing.T) {
+	query, args := buildAuditQuery(AuditFilter{})
+
+	require.Equal(t, selectAuditEntryColumns+" ORDER BY id DESC", query)
+	require.Empty(t, args)
+}
+
+func TestBuildAuditQueryWithAllFilters(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	query, args := buildAuditQuery(AuditFilter{
+		Username: "adminTax",
+		Endpoint: "/admin/deductions",
+		Status:   401,
+		From:     from,
+		To:       to,
+		Limit:    20,
+		Offset:   40,
+	})
+
+	require.Equal(t, selectAuditEntryColumns+" WHERE username = $1 AND endpoint LIKE $2 ESCAPE '\\' AND status = $3 AND occurred_at >= $4 AND occurred_at < $5 ORDER BY id DESC LIMIT $6 OFFSET $7", query)
+	require.Equal(t, []any{"adminTax", "/admin/deductions%", 401, from, to, 20, 40}, args)
+}
+
+func TestBuildAuditQueryEscapesEndpointWildcards(t *testing.T) {
+	_, args := buildAuditQuery(AuditFilter{Endpoint: `/admin/100%_off\x`})
+
+	require.Equal(t, []any{`/admin/100\%\_off\\x%`}, args)
+}
+
+func TestGetAuditEntries(t *testing.T) {
+	store, mock := setupMockStore()
+
+	rows := mock.NewRows(auditEntryColumns).
+		AddRow(2, time.Now(), "adminTax", true, "10.0.0.1", "req-2", "GET", "/admin/audit", "", 200).
+		AddRow(1, time.Now(), "mallory", false, "10.0.0.9", "req-1", "POST", "/admin/deductions/personal", "{}", 401)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAuditEntryColumns + " ORDER BY id DESC LIMIT $1")).WithArgs(50).WillReturnRows(rows)
+
+	got, err := NewHandler(store, store).getAuditEntries(context.Background(), AuditFilter{Limit: 50})
+
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.False(t, got[1].Authenticated)
+	require.Equal(t, 401, got[1].Status)
+}
+
+func expectAuditEntryInserted(mock sqlmock.Sqlmock, entry AuditEntry) {
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO admin_audit_log (username, authenticated, source_ip, request_id, method, endpoint, payload, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)).
+		WithArgs(entry.Username, entry.Authenticated, entry.SourceIP, entry.RequestID, entry.Method, entry.Endpoint, entry.Payload, entry.Status).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
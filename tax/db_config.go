@@ -0,0 +1,99 @@
+package tax
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"time"
+)
+
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+const sqlitePragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+type DBConfig struct {
+	URL             string
+	ReadURL         string
+	Dialect         Dialect
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	QueryTimeout    time.Duration
+}
+
+var DefaultDBConfig = DBConfig{
+	MaxOpenConns:    25,
+	MaxIdleConns:    25,
+	ConnMaxLifetime: 30 * time.Minute,
+	ConnMaxIdleTime: 5 * time.Minute,
+	QueryTimeout:    5 * time.Second,
+}
+
+func LoadDBConfig() DBConfig {
+	config := DefaultDBConfig
+	config.URL = os.Getenv("DATABASE_URL")
+	config.ReadURL = os.Getenv("DATABASE_READ_URL")
+	config.Dialect = getDialect(config.URL)
+
+	config.MaxOpenConns = GetEnvInt("DB_MAX_OPEN_CONNS", config.MaxOpenConns)
+	config.MaxIdleConns = GetEnvInt("DB_MAX_IDLE_CONNS", config.MaxIdleConns)
+	config.ConnMaxLifetime = GetEnvDuration("DB_CONN_MAX_LIFETIME", config.ConnMaxLifetime)
+	config.ConnMaxIdleTime = GetEnvDuration("DB_CONN_MAX_IDLE_TIME", config.ConnMaxIdleTime)
+	config.QueryTimeout = GetEnvDuration("DB_QUERY_TIMEOUT", config.QueryTimeout)
+
+	return config
+}
+
+// ReadReplica returns the config of the read replica, with the same pool
+// settings as the primary, when DATABASE_READ_URL is set.
+func (config DBConfig) ReadReplica() (DBConfig, bool) {
+	if config.ReadURL == "" {
+		return DBConfig{}, false
+	}
+
+	replica := config
+	replica.URL = config.ReadURL
+	replica.ReadURL = ""
+	replica.Dialect = getDialect(replica.URL)
+
+	return replica, true
+}
+
+func getDialect(url string) Dialect {
+	if strings.HasPrefix(url, "sqlite:") {
+		return DialectSQLite
+	}
+	return DialectPostgres
+}
+
+func (config DBConfig) dataSourceName() string {
+	if config.Dialect != DialectSQLite {
+		return config.URL
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(config.URL, "sqlite:"), "//")
+	if strings.Contains(path, "?") {
+		return "file:" + path + "&" + sqlitePragmas
+	}
+	return "file:" + path + "?" + sqlitePragmas
+}
+
+func (config DBConfig) apply(db *sql.DB) {
+	maxOpenConns := config.MaxOpenConns
+	if config.Dialect == DialectSQLite {
+		// SQLite allows one writer at a time, a single connection queues
+		// transactions instead of failing them with SQLITE_BUSY
+		maxOpenConns = 1
+	}
+
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+}
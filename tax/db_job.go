@@ -0,0 +1,187 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const selectTaxJobColumns = `SELECT id, status, filename, mode, sheet, detail, total_rows, processed_rows, invalid_rows, error, created_at, started_at, finished_at FROM tax_jobs`
+
+func (s *SQLStore) CreateJob(ctx context.Context, job BatchJob, input []byte) (BatchJob, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	job.Status = jobStatusQueued
+
+	err := s.db.QueryRowContext(ctx, `INSERT INTO tax_jobs (id, status, filename, mode, sheet, detail, input) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`,
+		job.ID, job.Status, job.Filename, job.Mode, job.Sheet, job.Detail, input,
+	).Scan(&job.CreatedAt)
+	if err != nil {
+		return BatchJob{}, classifyQueryError(ctx, err)
+	}
+
+	return job, nil
+}
+
+func (s *SQLStore) GetJob(ctx context.Context, id string) (BatchJob, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	job, err := scanTaxJob(s.db.QueryRowContext(ctx, selectTaxJobColumns+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return BatchJob{}, ErrJobNotFound
+	}
+	if err != nil {
+		return BatchJob{}, classifyQueryError(ctx, err)
+	}
+
+	return job, nil
+}
+
+func (s *SQLStore) GetJobInput(ctx context.Context, id string) ([]byte, error) {
+	return s.selectJobBytes(ctx, `SELECT input FROM tax_jobs WHERE id = $1`, id)
+}
+
+func (s *SQLStore) GetJobResult(ctx context.Context, id string) ([]byte, error) {
+	return s.selectJobBytes(ctx, `SELECT result FROM tax_jobs WHERE id = $1`, id)
+}
+
+func (s *SQLStore) selectJobBytes(ctx context.Context, query, id string) ([]byte, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var content []byte
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, classifyQueryError(ctx, err)
+	}
+
+	return content, nil
+}
+
+func (s *SQLStore) ClaimJob(ctx context.Context, id string, staleBefore time.Time) (bool, error) {
+	return s.updateJob(ctx, `UPDATE tax_jobs SET status = $1, started_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND (status = $3 OR (status = $1 AND updated_at < $4))`,
+		jobStatusRunning, id, jobStatusQueued, staleBefore.UTC())
+}
+
+func (s *SQLStore) UpdateJobProgress(ctx context.Context, id string, totalRows, processedRows, invalidRows int) (bool, error) {
+	return s.updateJob(ctx, `UPDATE tax_jobs SET total_rows = $1, processed_rows = $2, invalid_rows = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4 AND status = $5`,
+		totalRows, processedRows, invalidRows, id, jobStatusRunning)
+}
+
+func (s *SQLStore) TouchJob(ctx context.Context, id string) (bool, error) {
+	return s.updateJob(ctx, `UPDATE tax_jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = $2`, id, jobStatusRunning)
+}
+
+// FinishJob only finishes a running job, so a job cancelled meanwhile stays
+// cancelled.
+func (s *SQLStore) FinishJob(ctx context.Context, id, status, message string, result []byte) error {
+	_, err := s.updateJob(ctx, `UPDATE tax_jobs SET status = $1, error = $2, result = $3, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $4 AND status = $5`,
+		status, message, result, id, jobStatusRunning)
+	return err
+}
+
+func (s *SQLStore) CancelJob(ctx context.Context, id string) (BatchJob, error) {
+	cancelled, err := s.updateJob(ctx, `UPDATE tax_jobs SET status = $1, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND status IN ($3, $4)`,
+		jobStatusCancelled, id, jobStatusQueued, jobStatusRunning)
+	if err != nil {
+		return BatchJob{}, err
+	}
+
+	job, err := s.GetJob(ctx, id)
+	if err != nil {
+		return BatchJob{}, err
+	}
+	if !cancelled {
+		return job, ErrJobFinished
+	}
+
+	return job, nil
+}
+
+func (s *SQLStore) ListResumableJobs(ctx context.Context, staleBefore time.Time) ([]string, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM tax_jobs WHERE status = $1 OR (status = $2 AND updated_at < $3) ORDER BY created_at`,
+		jobStatusQueued, jobStatusRunning, staleBefore.UTC())
+	if err != nil {
+		return nil, classifyQueryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, classifyQueryError(ctx, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *SQLStore) DeleteFinishedJobs(ctx context.Context, finishedBefore time.Time) (int, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM tax_jobs WHERE status IN ($1, $2, $3) AND finished_at < $4`,
+		jobStatusSucceeded, jobStatusFailed, jobStatusCancelled, finishedBefore.UTC())
+	if err != nil {
+		return 0, classifyQueryError(ctx, err)
+	}
+
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+func (s *SQLStore) updateJob(ctx context.Context, query string, args ...any) (bool, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, classifyQueryError(ctx, err)
+	}
+
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+func scanTaxJob(row rowScanner) (BatchJob, error) {
+	var job BatchJob
+	var startedAt, finishedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID,
+		&job.Status,
+		&job.Filename,
+		&job.Mode,
+		&job.Sheet,
+		&job.Detail,
+		&job.TotalRows,
+		&job.ProcessedRows,
+		&job.InvalidRows,
+		&job.Error,
+		&job.CreatedAt,
+		&startedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return BatchJob{}, err
+	}
+
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return job, nil
+}
@@ -0,0 +1,90 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var taxJobColumns = []string{"id", "status", "filename", "mode", "sheet", "detail", "total_rows", "processed_rows", "invalid_rows", "error", "created_at", "started_at", "finished_at"}
+
+func TestGetTaxJobFromStore(t *testing.T) {
+	store, mock := setupMockStore()
+
+	createdAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(taxJobColumns).
+		AddRow("job-1", jobStatusRunning, "taxes.csv", csvModeReport, "", false, 10, 4, 1, "", createdAt, createdAt, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectTaxJobColumns + " WHERE id = $1")).WithArgs("job-1").WillReturnRows(rows)
+
+	got, err := store.GetJob(context.Background(), "job-1")
+
+	require.NoError(t, err)
+	require.Equal(t, 10, got.TotalRows)
+	require.Equal(t, 4, got.ProcessedRows)
+	require.NotNil(t, got.StartedAt)
+	require.Nil(t, got.FinishedAt)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetTaxJobFromStoreNotFound(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectTaxJobColumns + " WHERE id = $1")).WithArgs("job-1").WillReturnError(sql.ErrNoRows)
+
+	_, err := store.GetJob(context.Background(), "job-1")
+
+	require.ErrorIs(t, err, ErrJobNotFound)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCancelFinishedTaxJob(t *testing.T) {
+	store, mock := setupMockStore()
+
+	createdAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE tax_jobs SET status = $1, finished_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND status IN ($3, $4)`)).
+		WithArgs(jobStatusCancelled, "job-1", jobStatusQueued, jobStatusRunning).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := mock.NewRows(taxJobColumns).
+		AddRow("job-1", jobStatusSucceeded, "taxes.csv", csvModeStrict, "", false, 2, 2, 0, "", createdAt, createdAt, createdAt)
+	mock.ExpectQuery(regexp.QuoteMeta(selectTaxJobColumns + " WHERE id = $1")).WithArgs("job-1").WillReturnRows(rows)
+
+	got, err := store.CancelJob(context.Background(), "job-1")
+
+	require.ErrorIs(t, err, ErrJobFinished)
+	require.Equal(t, jobStatusSucceeded, got.Status)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteFinishedTaxJobs(t *testing.T) {
+	store, mock := setupMockStore()
+
+	finishedBefore := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM tax_jobs WHERE status IN ($1, $2, $3) AND finished_at < $4`)).
+		WithArgs(jobStatusSucceeded, jobStatusFailed, jobStatusCancelled, finishedBefore).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	deleted, err := store.DeleteFinishedJobs(context.Background(), finishedBefore)
+
+	require.NoError(t, err)
+	require.Equal(t, 3, deleted)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTouchTaxJobThatIsNoLongerRunning(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE tax_jobs SET updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = $2`)).
+		WithArgs("job-1", jobStatusRunning).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	running, err := store.TouchJob(context.Background(), "job-1")
+
+	require.NoError(t, err)
+	require.False(t, running)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,63 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	settingsChangedChannel = "allowance_settings_changed"
+	listenerPingInterval   = 90 * time.Second
+)
+
+func notifySettingsChanged(ctx context.Context, tx *sql.Tx, version int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, settingsChangedChannel, strconv.FormatInt(version, 10))
+	return err
+}
+
+type SettingsChangeListener interface {
+	settingsChanged(version int64)
+}
+
+func ListenSettingsChanges(ctx context.Context, dataSourceName string, listeners ...SettingsChangeListener) error {
+	listener := pq.NewListener(dataSourceName, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Settings listener: %v", err)
+		}
+	})
+
+	if err := listener.Listen(settingsChangedChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-listener.Notify:
+				// a nil notification means the connection was re-established and
+				// changes may have been missed, so it invalidates the cache too
+				var version int64
+				if notification != nil {
+					version, _ = strconv.ParseInt(notification.Extra, 10, 64)
+				}
+				for _, l := range listeners {
+					l.settingsChanged(version)
+				}
+			case <-time.After(listenerPingInterval):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
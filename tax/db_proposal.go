@@ -0,0 +1,197 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+)
+
+const insertDeductionProposal = `INSERT INTO deduction_proposals (allowance_type, amount, reason, base_version, status, proposed_by, rollback_of) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, proposed_at`
+
+const selectDeductionProposalColumns = `SELECT id, allowance_type, amount, reason, base_version, status, proposed_by, proposed_at, decided_by, decided_at, decision_reason, applied_version, rollback_of FROM deduction_proposals`
+
+func (s *SQLStore) CreateProposal(ctx context.Context, proposal DeductionProposal) (DeductionProposal, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	proposal.Status = proposalStatusPending
+
+	// a rollback proposal names the version to restore instead of an allowance type
+	allowanceType := sql.NullString{String: proposal.AllowanceType, Valid: proposal.RollbackOf == nil}
+
+	err := s.db.QueryRowContext(ctx, insertDeductionProposal,
+		allowanceType, proposal.Amount, proposal.Reason, proposal.BaseVersion, proposal.Status, proposal.ProposedBy, proposal.RollbackOf,
+	).Scan(&proposal.ID, &proposal.ProposedAt)
+	if err != nil {
+		return DeductionProposal{}, classifyQueryError(ctx, err)
+	}
+
+	return proposal, nil
+}
+
+func (s *SQLStore) ListProposals(ctx context.Context, status string) ([]DeductionProposal, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, selectDeductionProposalColumns+" WHERE status = $1 ORDER BY id", status)
+	if err != nil {
+		return nil, classifyQueryError(ctx, err)
+	}
+	defer rows.Close()
+
+	proposals := []DeductionProposal{}
+	for rows.Next() {
+		proposal, err := scanDeductionProposal(rows)
+		if err != nil {
+			return nil, classifyQueryError(ctx, err)
+		}
+		proposals = append(proposals, proposal)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classifyQueryError(ctx, err)
+	}
+
+	return proposals, nil
+}
+
+func (s *SQLStore) ApproveProposal(ctx context.Context, id int64, approvedBy, reason string) (DeductionProposal, AllowanceVersion, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return DeductionProposal{}, AllowanceVersion{}, classifyQueryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	proposal, err := s.lockPendingDeductionProposal(ctx, tx, id)
+	if err != nil {
+		return DeductionProposal{}, AllowanceVersion{}, err
+	}
+
+	if proposal.ProposedBy == approvedBy {
+		return DeductionProposal{}, AllowanceVersion{}, ErrSelfApproval
+	}
+
+	var version AllowanceVersion
+	if proposal.RollbackOf != nil {
+		version, err = s.applyRollback(ctx, tx, *proposal.RollbackOf, proposal.BaseVersion, approvedBy, proposal.Reason)
+	} else {
+		version, err = s.applyAllowanceAmount(ctx, tx, proposal.AllowanceType, proposal.Amount, proposal.BaseVersion, approvedBy, proposal.Reason)
+	}
+	if err != nil {
+		return proposal, AllowanceVersion{}, err
+	}
+
+	proposal, err = decideDeductionProposal(ctx, tx, proposal, proposalStatusApproved, approvedBy, reason, &version.Version)
+	if err != nil {
+		return DeductionProposal{}, AllowanceVersion{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return DeductionProposal{}, AllowanceVersion{}, classifyQueryError(ctx, err)
+	}
+
+	return proposal, version, nil
+}
+
+func (s *SQLStore) RejectProposal(ctx context.Context, id int64, rejectedBy, reason string) (DeductionProposal, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return DeductionProposal{}, classifyQueryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	proposal, err := s.lockPendingDeductionProposal(ctx, tx, id)
+	if err != nil {
+		return DeductionProposal{}, err
+	}
+
+	proposal, err = decideDeductionProposal(ctx, tx, proposal, proposalStatusRejected, rejectedBy, reason, nil)
+	if err != nil {
+		return DeductionProposal{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return DeductionProposal{}, classifyQueryError(ctx, err)
+	}
+
+	return proposal, nil
+}
+
+func (s *SQLStore) lockPendingDeductionProposal(ctx context.Context, tx *sql.Tx, id int64) (DeductionProposal, error) {
+	proposal, err := scanDeductionProposal(tx.QueryRowContext(ctx, selectDeductionProposalColumns+" WHERE id = $1"+s.dialect.forUpdate(), id))
+	if err == sql.ErrNoRows {
+		return DeductionProposal{}, ErrProposalNotFound
+	}
+	if err != nil {
+		return DeductionProposal{}, classifyQueryError(ctx, err)
+	}
+
+	if proposal.Status != proposalStatusPending {
+		return DeductionProposal{}, ErrProposalNotPending
+	}
+
+	return proposal, nil
+}
+
+func decideDeductionProposal(ctx context.Context, tx *sql.Tx, proposal DeductionProposal, status, decidedBy, reason string, appliedVersion *int64) (DeductionProposal, error) {
+	var decidedAt sql.NullTime
+
+	err := tx.QueryRowContext(ctx, `UPDATE deduction_proposals SET status = $1, decided_by = $2, decided_at = CURRENT_TIMESTAMP, decision_reason = $3, applied_version = $4 WHERE id = $5 RETURNING decided_at`,
+		status, decidedBy, reason, appliedVersion, proposal.ID,
+	).Scan(&decidedAt)
+	if err != nil {
+		return DeductionProposal{}, classifyQueryError(ctx, err)
+	}
+
+	proposal.Status = status
+	proposal.DecidedBy = decidedBy
+	proposal.DecidedAt = &decidedAt.Time
+	proposal.DecisionReason = reason
+	proposal.AppliedVersion = appliedVersion
+
+	return proposal, nil
+}
+
+func scanDeductionProposal(row rowScanner) (DeductionProposal, error) {
+	var proposal DeductionProposal
+	var allowanceType, decidedBy sql.NullString
+	var decidedAt sql.NullTime
+	var appliedVersion, rollbackOf sql.NullInt64
+
+	err := row.Scan(
+		&proposal.ID,
+		&allowanceType,
+		&proposal.Amount,
+		&proposal.Reason,
+		&proposal.BaseVersion,
+		&proposal.Status,
+		&proposal.ProposedBy,
+		&proposal.ProposedAt,
+		&decidedBy,
+		&decidedAt,
+		&proposal.DecisionReason,
+		&appliedVersion,
+		&rollbackOf,
+	)
+	if err != nil {
+		return DeductionProposal{}, err
+	}
+
+	proposal.AllowanceType = allowanceType.String
+	proposal.DecidedBy = decidedBy.String
+	if decidedAt.Valid {
+		proposal.DecidedAt = &decidedAt.Time
+	}
+	if appliedVersion.Valid {
+		proposal.AppliedVersion = &appliedVersion.Int64
+	}
+	if rollbackOf.Valid {
+		proposal.RollbackOf = &rollbackOf.Int64
+	}
+
+	return proposal, nil
+}
@@ -0,0 +1,195 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var deductionProposalColumns = []string{"id", "allowance_type", "amount", "reason", "base_version", "status", "proposed_by", "proposed_at", "decided_by", "decided_at", "decision_reason", "applied_version", "rollback_of"}
+
+func TestCreateDeductionProposal(t *testing.T) {
+	store, mock := setupMockStore()
+
+	expectDeductionProposalCreated(mock, "personal", 70000, 1, "alice", 3)
+
+	got, err := store.CreateProposal(context.Background(), DeductionProposal{
+		AllowanceType: "personal",
+		Amount:        70000,
+		BaseVersion:   1,
+		ProposedBy:    "alice",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, int64(3), got.ID)
+	require.Equal(t, proposalStatusPending, got.Status)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDeductionProposals(t *testing.T) {
+	store, mock := setupMockStore()
+
+	proposedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(deductionProposalColumns).
+		AddRow(1, "personal", 70000, "", 1, "approved", "alice", proposedAt, "bob", proposedAt, "ok", 2, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns + " WHERE status = $1 ORDER BY id")).
+		WithArgs("approved").WillReturnRows(rows)
+
+	got, err := store.ListProposals(context.Background(), "approved")
+
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	require.Equal(t, "bob", got[0].DecidedBy)
+	require.Equal(t, int64(2), *got[0].AppliedVersion)
+}
+
+func TestApproveDeductionProposal(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	expectAllowanceSettingsLocked(mock, 1)
+	expectAllowanceVersionSaved(mock, AllowanceSettings{Personal: 70000, Donation: 100000, KReceipt: 50000}, "bob", 1, nil, 2)
+	expectDeductionProposalDecided(mock, proposalStatusApproved, "bob", 2, 3)
+	mock.ExpectCommit()
+
+	proposal, version, err := store.ApproveProposal(context.Background(), 3, "bob", "looks good")
+
+	require.NoError(t, err)
+	require.Equal(t, proposalStatusApproved, proposal.Status)
+	require.Equal(t, "bob", proposal.DecidedBy)
+	require.Equal(t, int64(2), *proposal.AppliedVersion)
+	require.Equal(t, int64(2), version.Version)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApproveRollbackProposal(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectPendingRollbackProposalLocked(mock, 4, "alice", 3, 2)
+	expectAllowanceSettingsLocked(mock, 3)
+	expectAllowanceCaps(mock, 2, AllowanceSettings{Personal: 80000, Donation: 100000, KReceipt: 50000})
+	expectAllowanceVersionSaved(mock, AllowanceSettings{Personal: 80000, Donation: 100000, KReceipt: 50000}, "bob", 3, 2, 4)
+	expectDeductionProposalDecided(mock, proposalStatusApproved, "bob", 4, 4)
+	mock.ExpectCommit()
+
+	proposal, version, err := store.ApproveProposal(context.Background(), 4, "bob", "")
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2), *proposal.RollbackOf)
+	require.Empty(t, proposal.AllowanceType)
+	require.Equal(t, int64(4), *proposal.AppliedVersion)
+	require.Equal(t, int64(2), *version.RollbackOf)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApproveDeductionProposalBySameAdmin(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	mock.ExpectRollback()
+
+	_, _, err := store.ApproveProposal(context.Background(), 3, "alice", "")
+
+	require.ErrorIs(t, err, ErrSelfApproval)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApproveDeductionProposalWhenSettingsChanged(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	expectAllowanceSettingsLocked(mock, 2)
+	mock.ExpectRollback()
+
+	proposal, _, err := store.ApproveProposal(context.Background(), 3, "bob", "")
+
+	var changed *SettingsChangedError
+	require.ErrorAs(t, err, &changed)
+	require.Equal(t, "personal", proposal.AllowanceType)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApproveDeductionProposalAlreadyDecided(t *testing.T) {
+	store, mock := setupMockStore()
+
+	proposedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(deductionProposalColumns).
+		AddRow(3, "personal", 70000, "", 1, "rejected", "alice", proposedAt, "bob", proposedAt, "", nil, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns + " WHERE id = $1 FOR UPDATE")).WithArgs(3).WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	_, _, err := store.ApproveProposal(context.Background(), 3, "bob", "")
+
+	require.ErrorIs(t, err, ErrProposalNotPending)
+}
+
+func TestRejectDeductionProposal(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectPendingDeductionProposalLocked(mock, 3, "alice", 1)
+	expectDeductionProposalDecided(mock, proposalStatusRejected, "alice", nil, 3)
+	mock.ExpectCommit()
+
+	got, err := store.RejectProposal(context.Background(), 3, "alice", "typo")
+
+	require.NoError(t, err)
+	require.Equal(t, proposalStatusRejected, got.Status)
+	require.Nil(t, got.AppliedVersion)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRejectDeductionProposalNotFound(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns + " WHERE id = $1 FOR UPDATE")).WithArgs(9).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err := store.RejectProposal(context.Background(), 9, "alice", "")
+
+	require.ErrorIs(t, err, ErrProposalNotFound)
+}
+
+func expectDeductionProposalCreated(mock sqlmock.Sqlmock, allowanceType string, amount float64, baseVersion int64, proposedBy string, id int64) {
+	rows := mock.NewRows([]string{"id", "proposed_at"}).AddRow(id, time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(insertDeductionProposal)).
+		WithArgs(allowanceType, amount, sqlmock.AnyArg(), baseVersion, proposalStatusPending, proposedBy, nil).
+		WillReturnRows(rows)
+}
+
+func expectRollbackProposalCreated(mock sqlmock.Sqlmock, rollbackOf, baseVersion int64, proposedBy string, id int64) {
+	rows := mock.NewRows([]string{"id", "proposed_at"}).AddRow(id, time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(insertDeductionProposal)).
+		WithArgs(nil, 0.0, sqlmock.AnyArg(), baseVersion, proposalStatusPending, proposedBy, rollbackOf).
+		WillReturnRows(rows)
+}
+
+func expectPendingDeductionProposalLocked(mock sqlmock.Sqlmock, id int64, proposedBy string, baseVersion int64) {
+	rows := mock.NewRows(deductionProposalColumns).
+		AddRow(id, "personal", 70000, "", baseVersion, proposalStatusPending, proposedBy, time.Now(), nil, nil, "", nil, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns + " WHERE id = $1 FOR UPDATE")).WithArgs(id).WillReturnRows(rows)
+}
+
+func expectPendingRollbackProposalLocked(mock sqlmock.Sqlmock, id int64, proposedBy string, baseVersion, rollbackOf int64) {
+	rows := mock.NewRows(deductionProposalColumns).
+		AddRow(id, nil, 0, "", baseVersion, proposalStatusPending, proposedBy, time.Now(), nil, nil, "", nil, rollbackOf)
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns + " WHERE id = $1 FOR UPDATE")).WithArgs(id).WillReturnRows(rows)
+}
+
+func expectDeductionProposalDecided(mock sqlmock.Sqlmock, status, decidedBy string, appliedVersion any, id int64) {
+	rows := mock.NewRows([]string{"decided_at"}).AddRow(time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`UPDATE deduction_proposals SET status = $1, decided_by = $2, decided_at = CURRENT_TIMESTAMP, decision_reason = $3, applied_version = $4 WHERE id = $5 RETURNING decided_at`)).
+		WithArgs(status, decidedBy, sqlmock.AnyArg(), appliedVersion, id).
+		WillReturnRows(rows)
+}
@@ -0,0 +1,262 @@
+package tax
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setupSQLiteStore(t *testing.T) (*SQLStore, *Migrator) {
+	t.Helper()
+
+	config := DefaultDBConfig
+	config.URL = "sqlite://" + filepath.Join(t.TempDir(), "ktax.db")
+	config.Dialect = getDialect(config.URL)
+
+	db := ConnectDb(config)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := NewMigrator(db, config.Dialect)
+	require.NoError(t, err)
+
+	return NewSQLStore(db, config), migrator
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store, migrator := setupSQLiteStore(t)
+	ctx := context.Background()
+
+	// checking the version of a new database does not create any table
+	require.Equal(t, &SchemaVersionError{Current: 0, Expected: migrator.LatestVersion()}, migrator.CheckVersion(ctx))
+	var tables int
+	require.NoError(t, store.db.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables))
+	require.Zero(t, tables)
+
+	version, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, migrator.LatestVersion(), version)
+	require.NoError(t, migrator.CheckVersion(ctx))
+
+	snapshot, err := store.LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, SettingsSnapshot{Version: 1, Allowances: DefaultAllowanceSettings}, snapshot)
+
+	proposal, err := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "maker"})
+	require.NoError(t, err)
+	require.NotZero(t, proposal.ID)
+
+	pending, err := store.ListProposals(ctx, proposalStatusPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+
+	approved, applied, err := store.ApproveProposal(ctx, proposal.ID, "checker", "ok")
+	require.NoError(t, err)
+	require.Equal(t, proposalStatusApproved, approved.Status)
+	require.NotNil(t, approved.DecidedAt)
+	require.Equal(t, int64(2), applied.Version)
+	require.Equal(t, 70000.0, applied.Settings.Personal)
+
+	rollbackOf := int64(1)
+	rollback, err := store.CreateProposal(ctx, DeductionProposal{RollbackOf: &rollbackOf, Reason: "undo", BaseVersion: 2, ProposedBy: "maker"})
+	require.NoError(t, err)
+
+	stale, err := store.CreateProposal(ctx, DeductionProposal{RollbackOf: &rollbackOf, Reason: "stale", BaseVersion: 2, ProposedBy: "maker"})
+	require.NoError(t, err)
+
+	approved, rolledBack, err := store.ApproveProposal(ctx, rollback.ID, "checker", "ok")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), *approved.RollbackOf)
+	require.Empty(t, approved.AllowanceType)
+	require.Equal(t, int64(3), rolledBack.Version)
+
+	_, _, err = store.ApproveProposal(ctx, stale.ID, "checker", "")
+	var changed *SettingsChangedError
+	require.ErrorAs(t, err, &changed)
+	require.Equal(t, int64(3), changed.Current.Version)
+
+	versions, err := store.ListVersions(ctx)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, DefaultAllowanceSettings, versions[0].Settings)
+
+	snapshot, err = store.LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), snapshot.Version)
+
+	start := time.Now().Add(-time.Minute)
+	require.NoError(t, store.AppendAuditEntry(ctx, AuditEntry{Username: "checker", Authenticated: true, SourceIP: "127.0.0.1", RequestID: "r1", Method: "POST", Endpoint: "/admin/deductions/personal", Payload: "{}", Status: 200}))
+
+	var entries []AuditEntry
+	err = store.ScanAuditEntries(ctx, AuditFilter{Username: "checker", From: start}, func(entry AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "r1", entries[0].RequestID)
+
+	// _ and % in the endpoint filter are not wildcards
+	for _, endpoint := range []string{"/admin/deductions_", "/admin/%"} {
+		err = store.ScanAuditEntries(ctx, AuditFilter{Endpoint: endpoint}, func(entry AuditEntry) error {
+			t.Errorf("endpoint %s matched %s", endpoint, entry.Endpoint)
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	version, err = migrator.Down(ctx, int(version))
+	require.NoError(t, err)
+	require.Zero(t, version)
+
+	version, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, migrator.LatestVersion(), version)
+}
+
+// a database created from the old db/query/init.sql has the allowances table
+// of the first migration and no schema version
+func TestSQLiteMigrateFromInitSQL(t *testing.T) {
+	store, migrator := setupSQLiteStore(t)
+	ctx := context.Background()
+
+	_, err := store.db.ExecContext(ctx, `CREATE TABLE "allowances" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"donation" bigint NOT NULL,
+		"personal" bigint NOT NULL,
+		"k-receipt" bigint NOT NULL
+	)`)
+	require.NoError(t, err)
+	_, err = store.db.ExecContext(ctx, `INSERT INTO "allowances" ("donation", "personal", "k-receipt") VALUES (100000, 70000, 50000)`)
+	require.NoError(t, err)
+
+	require.NoError(t, migrator.Force(ctx, 1))
+
+	version, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.Equal(t, migrator.LatestVersion(), version)
+	require.NoError(t, migrator.CheckVersion(ctx))
+
+	snapshot, err := store.LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, AllowanceSettings{Personal: 70000, Donation: 100000, KReceipt: 50000}, snapshot.Allowances)
+
+	proposal, err := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "k-receipt", Amount: 60000, BaseVersion: snapshot.Version, ProposedBy: "maker"})
+	require.NoError(t, err)
+	_, _, err = store.ApproveProposal(ctx, proposal.ID, "checker", "")
+	require.NoError(t, err)
+}
+
+func TestSQLiteKeepsCapsOfAddedDeductionTypes(t *testing.T) {
+	store, migrator := setupSQLiteStore(t)
+	ctx := context.Background()
+
+	_, err := migrator.Up(ctx)
+	require.NoError(t, err)
+
+	_, err = store.db.ExecContext(ctx, `INSERT INTO deduction_types (code, description, min_amount, max_amount) VALUES ('insurance', 'เบี้ยประกันชีวิต', 0, 100000), ('rmf', 'กองทุน RMF', 500, 500000)`)
+	require.NoError(t, err)
+	_, err = store.db.ExecContext(ctx, `INSERT INTO allowance_caps (version_id, deduction_type, amount) VALUES (1, 'insurance', 100000)`)
+	require.NoError(t, err)
+
+	proposal, err := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "maker"})
+	require.NoError(t, err)
+	_, version, err := store.ApproveProposal(ctx, proposal.ID, "checker", "")
+	require.NoError(t, err)
+
+	caps := map[string]float64{}
+	rows, err := store.db.QueryContext(ctx, `SELECT deduction_type, amount FROM allowance_caps WHERE version_id = $1`, version.Version)
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var allowanceType string
+		var amount float64
+		require.NoError(t, rows.Scan(&allowanceType, &amount))
+		caps[allowanceType] = amount
+	}
+	require.NoError(t, rows.Err())
+
+	require.Equal(t, map[string]float64{"personal": 70000, "donation": 100000, "k-receipt": 50000, "insurance": 100000, "rmf": 500}, caps)
+
+	limit, err := store.DeductionLimit(ctx, "rmf")
+	require.NoError(t, err)
+	require.Equal(t, DeductionLimit{Min: 500, Max: 500000}, limit)
+}
+
+func TestSQLiteJobStore(t *testing.T) {
+	store, migrator := setupSQLiteStore(t)
+	ctx := context.Background()
+
+	_, err := migrator.Up(ctx)
+	require.NoError(t, err)
+
+	job, err := store.CreateJob(ctx, BatchJob{ID: "job-1", Filename: "taxes.csv", Mode: csvModeStrict, Detail: true}, []byte("totalIncome\n500000\n"))
+	require.NoError(t, err)
+	require.Equal(t, jobStatusQueued, job.Status)
+	require.False(t, job.CreatedAt.IsZero())
+
+	ids, err := store.ListResumableJobs(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []string{"job-1"}, ids)
+
+	claimed, err := store.ClaimJob(ctx, "job-1", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	claimed, err = store.ClaimJob(ctx, "job-1", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.False(t, claimed)
+
+	ids, err = store.ListResumableJobs(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	ids, err = store.ListResumableJobs(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, []string{"job-1"}, ids)
+
+	running, err := store.UpdateJobProgress(ctx, "job-1", 1, 1, 0)
+	require.NoError(t, err)
+	require.True(t, running)
+
+	running, err = store.TouchJob(ctx, "job-1")
+	require.NoError(t, err)
+	require.True(t, running)
+
+	input, err := store.GetJobInput(ctx, "job-1")
+	require.NoError(t, err)
+	require.Equal(t, "totalIncome\n500000\n", string(input))
+
+	require.NoError(t, store.FinishJob(ctx, "job-1", jobStatusSucceeded, "", []byte(`{"taxes":[]}`)))
+
+	job, err = store.GetJob(ctx, "job-1")
+	require.NoError(t, err)
+	require.Equal(t, jobStatusSucceeded, job.Status)
+	require.True(t, job.Detail)
+	require.Equal(t, 1, job.ProcessedRows)
+	require.NotNil(t, job.StartedAt)
+	require.NotNil(t, job.FinishedAt)
+
+	result, err := store.GetJobResult(ctx, "job-1")
+	require.NoError(t, err)
+	require.Equal(t, `{"taxes":[]}`, string(result))
+
+	_, err = store.CancelJob(ctx, "job-1")
+	require.ErrorIs(t, err, ErrJobFinished)
+
+	_, err = store.GetJob(ctx, "job-2")
+	require.ErrorIs(t, err, ErrJobNotFound)
+
+	deleted, err := store.DeleteFinishedJobs(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+
+	deleted, err = store.DeleteFinishedJobs(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+
+	_, err = store.GetJob(ctx, "job-1")
+	require.ErrorIs(t, err, ErrJobNotFound)
+}
@@ -1,90 +1,61 @@
 package tax
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/require"
 )
 
-func TestGetPersonalAllowanceValid(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+func TestGetSettingsSnapshotValid(t *testing.T) {
+	store, mock := setupMockStore()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(60000)
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	expectSettingsSnapshot(mock, 3)
 
-	got, err := getPersonalAllowance()
+	got, err := store.LoadSettings(context.Background())
 
 	require.NoError(t, err)
-	require.NotEmpty(t, got)
-	require.Equal(t, 60000.0, got)
+	require.Equal(t, SettingsSnapshot{
+		Version:    3,
+		Allowances: AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 50000},
+	}, got)
 }
 
-func TestGetPersonalAllowanceReturnError(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+func TestGetSettingsSnapshotReturnError(t *testing.T) {
+	store, mock := setupMockStore()
 
-	mock.ExpectQuery("SELECT personal FROM allowances WHERE id = ?").WithArgs(1).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).
+		WillReturnError(sql.ErrNoRows)
 
-	got, err := getPersonalAllowance()
+	got, err := store.LoadSettings(context.Background())
 
 	require.Empty(t, got)
 	require.EqualError(t, err, "no record found with the specified id")
 }
 
-func TestGetDonationAllowanceValid(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
+func TestGetSettingsSnapshotWithoutCurrentYear(t *testing.T) {
+	store, mock := setupMockStore()
 
-	rows := mock.NewRows([]string{"personal"}).AddRow(100000)
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnRows(rows)
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).
+		WillReturnRows(mock.NewRows([]string{"version", "deduction_type", "amount"}))
 
-	got, err := getDonationAllowance()
+	_, err := store.LoadSettings(context.Background())
 
-	require.NoError(t, err)
-	require.NotEmpty(t, got)
-	require.Equal(t, 100000.0, got)
+	require.ErrorIs(t, err, ErrSettingsNotFound)
 }
 
-func TestGetDonationAllowanceReturnError(t *testing.T) {
+func setupMockStore() (*SQLStore, sqlmock.Sqlmock) {
 	db, mock := setupMockDB()
-	conn = db
-
-	mock.ExpectQuery("SELECT donation FROM allowances WHERE id = ?").WithArgs(1).WillReturnError(sql.ErrNoRows)
-
-	got, err := getDonationAllowance()
-
-	require.Empty(t, got)
-	require.EqualError(t, err, "no record found with the specified id")
+	return NewPostgresStore(db, DefaultDBConfig.QueryTimeout), mock
 }
 
-func TestGetKReceiptAllowanceValid(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
-
-	rows := mock.NewRows([]string{"personal"}).AddRow(50000)
-	mock.ExpectQuery(`SELECT "k-receipt" FROM allowances WHERE id = ?`).WithArgs(1).WillReturnRows(rows)
-
-	got, err := getKReceiptAllowance()
-
-	require.NoError(t, err)
-	require.NotEmpty(t, got)
-	require.Equal(t, 50000.0, got)
-}
-
-func TestGetKReceiptAllowanceReturnError(t *testing.T) {
-	db, mock := setupMockDB()
-	conn = db
-
-	mock.ExpectQuery("SELECT k-receipt FROM allowances WHERE id = ?").WithArgs(1).WillReturnError(sql.ErrNoRows)
-
-	got, err := getKReceiptAllowance()
-
-	require.Empty(t, got)
-	require.EqualError(t, err, "no record found with the specified id")
+func setupMockHandler() (*Handler, sqlmock.Sqlmock) {
+	store, mock := setupMockStore()
+	return NewHandler(store, store), mock
 }
 
 func setupMockDB() (*sql.DB, sqlmock.Sqlmock) {
@@ -94,3 +65,11 @@ func setupMockDB() (*sql.DB, sqlmock.Sqlmock) {
 	}
 	return db, mock
 }
+
+func settingsSnapshotRows(mock sqlmock.Sqlmock, version int64, settings AllowanceSettings) *sqlmock.Rows {
+	rows := mock.NewRows([]string{"version", "deduction_type", "amount"})
+	for _, allowanceType := range deductionTypes {
+		rows.AddRow(version, allowanceType, settings.get(allowanceType))
+	}
+	return rows
+}
@@ -0,0 +1,242 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+)
+
+const selectAllowanceVersions = `SELECT allowance_versions.id, allowance_versions.changed_by, allowance_versions.changed_at, allowance_versions.reason, allowance_versions.previous_version, allowance_versions.rollback_of, allowance_caps.deduction_type, allowance_caps.amount FROM allowance_versions JOIN allowance_caps ON allowance_caps.version_id = allowance_versions.id`
+
+// carryOverAllowanceCaps copies the caps of deduction types that
+// AllowanceSettings has no field for from version $2 to version $1, so a kind
+// added to deduction_types is kept in every version. A kind without a cap yet
+// starts at its minimum.
+const carryOverAllowanceCaps = `INSERT INTO allowance_caps (version_id, deduction_type, amount) SELECT CAST($1 AS bigint), deduction_types.code, COALESCE(allowance_caps.amount, deduction_types.min_amount) FROM deduction_types LEFT JOIN allowance_caps ON allowance_caps.version_id = $2 AND allowance_caps.deduction_type = deduction_types.code WHERE deduction_types.code NOT IN (SELECT deduction_type FROM allowance_caps WHERE version_id = $1)`
+
+func (s *SQLStore) applyAllowanceAmount(ctx context.Context, tx *sql.Tx, allowanceType string, amount float64, expectedVersion int64, changedBy, reason string) (AllowanceVersion, error) {
+	settings, currentVersion, err := s.lockAllowanceSettings(ctx, tx)
+	if err != nil {
+		return AllowanceVersion{}, err
+	}
+
+	if !versionMatches(expectedVersion, currentVersion) {
+		return AllowanceVersion{}, &SettingsChangedError{Current: SettingsSnapshot{Version: currentVersion, Allowances: settings}}
+	}
+
+	newSettings := settings
+	newSettings.set(allowanceType, amount)
+
+	return s.saveAllowanceVersion(ctx, tx, AllowanceVersion{
+		Settings:        newSettings,
+		ChangedBy:       changedBy,
+		Reason:          reason,
+		PreviousVersion: &currentVersion,
+		Changes:         diffAllowanceSettings(settings, newSettings),
+	})
+}
+
+func (s *SQLStore) applyRollback(ctx context.Context, tx *sql.Tx, targetVersion, expectedVersion int64, changedBy, reason string) (AllowanceVersion, error) {
+	currentSettings, currentVersion, err := s.lockAllowanceSettings(ctx, tx)
+	if err != nil {
+		return AllowanceVersion{}, err
+	}
+
+	if !versionMatches(expectedVersion, currentVersion) {
+		return AllowanceVersion{}, &SettingsChangedError{Current: SettingsSnapshot{Version: currentVersion, Allowances: currentSettings}}
+	}
+
+	if targetVersion == currentVersion {
+		return AllowanceVersion{}, ErrVersionAlreadyActive
+	}
+
+	settings, err := selectAllowanceCaps(ctx, tx, targetVersion)
+	if err != nil {
+		return AllowanceVersion{}, err
+	}
+
+	return s.saveAllowanceVersion(ctx, tx, AllowanceVersion{
+		Settings:        settings,
+		ChangedBy:       changedBy,
+		Reason:          reason,
+		PreviousVersion: &currentVersion,
+		RollbackOf:      &targetVersion,
+		Changes:         diffAllowanceSettings(currentSettings, settings),
+	})
+}
+
+func (s *SQLStore) lockAllowanceSettings(ctx context.Context, tx *sql.Tx) (AllowanceSettings, int64, error) {
+	var version int64
+
+	err := tx.QueryRowContext(ctx, `SELECT version FROM tax_years WHERE is_current`+s.dialect.forUpdate()).Scan(&version)
+	if err != nil {
+		return AllowanceSettings{}, 0, classifyQueryError(ctx, err)
+	}
+
+	settings, err := selectAllowanceCaps(ctx, tx, version)
+	if err != nil {
+		return AllowanceSettings{}, 0, err
+	}
+
+	return settings, version, nil
+}
+
+func selectAllowanceCaps(ctx context.Context, tx *sql.Tx, version int64) (AllowanceSettings, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT deduction_type, amount FROM allowance_caps WHERE version_id = $1`, version)
+	if err != nil {
+		return AllowanceSettings{}, classifyQueryError(ctx, err)
+	}
+	defer rows.Close()
+
+	var settings AllowanceSettings
+	found := false
+
+	for rows.Next() {
+		var allowanceType string
+		var amount float64
+
+		if err := rows.Scan(&allowanceType, &amount); err != nil {
+			return AllowanceSettings{}, classifyQueryError(ctx, err)
+		}
+
+		settings.set(allowanceType, amount)
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return AllowanceSettings{}, classifyQueryError(ctx, err)
+	}
+
+	if !found {
+		return AllowanceSettings{}, ErrVersionNotFound
+	}
+
+	return settings, nil
+}
+
+func (s *SQLStore) saveAllowanceVersion(ctx context.Context, tx *sql.Tx, version AllowanceVersion) (AllowanceVersion, error) {
+	err := tx.QueryRowContext(ctx, `INSERT INTO allowance_versions (changed_by, reason, previous_version, rollback_of) VALUES ($1, $2, $3, $4) RETURNING id, changed_at`,
+		version.ChangedBy, version.Reason, version.PreviousVersion, version.RollbackOf,
+	).Scan(&version.Version, &version.ChangedAt)
+	if err != nil {
+		return AllowanceVersion{}, classifyQueryError(ctx, err)
+	}
+
+	for _, allowanceType := range deductionTypes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO allowance_caps (version_id, deduction_type, amount) VALUES ($1, $2, $3)`,
+			version.Version, allowanceType, version.Settings.get(allowanceType))
+		if err != nil {
+			return AllowanceVersion{}, classifyQueryError(ctx, err)
+		}
+	}
+
+	// a rollback takes the other kinds from the version it restores
+	source := version.PreviousVersion
+	if version.RollbackOf != nil {
+		source = version.RollbackOf
+	}
+
+	_, err = tx.ExecContext(ctx, carryOverAllowanceCaps, version.Version, source)
+	if err != nil {
+		return AllowanceVersion{}, classifyQueryError(ctx, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tax_years SET version = $1 WHERE is_current`, version.Version)
+	if err != nil {
+		return AllowanceVersion{}, classifyQueryError(ctx, err)
+	}
+
+	if s.dialect == DialectPostgres {
+		if err := notifySettingsChanged(ctx, tx, version.Version); err != nil {
+			return AllowanceVersion{}, classifyQueryError(ctx, err)
+		}
+	}
+
+	return version, nil
+}
+
+func (s *SQLStore) ListVersions(ctx context.Context) ([]AllowanceVersion, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, selectAllowanceVersions+" ORDER BY allowance_versions.id DESC")
+	if err != nil {
+		return nil, classifyQueryError(ctx, err)
+	}
+	defer rows.Close()
+
+	versions, err := scanAllowanceVersions(rows)
+	if err != nil {
+		return nil, classifyQueryError(ctx, err)
+	}
+
+	return versions, nil
+}
+
+func (s *SQLStore) GetVersion(ctx context.Context, id int64) (AllowanceVersion, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, selectAllowanceVersions+" WHERE allowance_versions.id = $1", id)
+	if err != nil {
+		return AllowanceVersion{}, classifyQueryError(ctx, err)
+	}
+	defer rows.Close()
+
+	versions, err := scanAllowanceVersions(rows)
+	if err != nil {
+		return AllowanceVersion{}, classifyQueryError(ctx, err)
+	}
+
+	if len(versions) == 0 {
+		return AllowanceVersion{}, ErrVersionNotFound
+	}
+
+	return versions[0], nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanAllowanceVersions folds the one row per deduction type returned by
+// selectAllowanceVersions into one AllowanceVersion per id.
+func scanAllowanceVersions(rows *sql.Rows) ([]AllowanceVersion, error) {
+	versions := []AllowanceVersion{}
+
+	for rows.Next() {
+		var version AllowanceVersion
+		var previousVersion, rollbackOf sql.NullInt64
+		var allowanceType string
+		var amount float64
+
+		err := rows.Scan(
+			&version.Version,
+			&version.ChangedBy,
+			&version.ChangedAt,
+			&version.Reason,
+			&previousVersion,
+			&rollbackOf,
+			&allowanceType,
+			&amount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if last := len(versions) - 1; last >= 0 && versions[last].Version == version.Version {
+			versions[last].Settings.set(allowanceType, amount)
+			continue
+		}
+
+		if previousVersion.Valid {
+			version.PreviousVersion = &previousVersion.Int64
+		}
+		if rollbackOf.Valid {
+			version.RollbackOf = &rollbackOf.Int64
+		}
+
+		version.Settings.set(allowanceType, amount)
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
@@ -0,0 +1,212 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var allowanceVersionColumns = []string{"id", "changed_by", "changed_at", "reason", "previous_version", "rollback_of", "deduction_type", "amount"}
+
+func TestApplyAllowanceAmount(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectAllowanceSettingsLocked(mock, 1)
+	expectAllowanceVersionSaved(mock, AllowanceSettings{Personal: 70000, Donation: 100000, KReceipt: 50000}, "adminTax", 1, nil, 2)
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+
+	got, err := store.applyAllowanceAmount(context.Background(), tx, "personal", 70000, 1, "adminTax", "")
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2), got.Version)
+	require.Equal(t, int64(1), *got.PreviousVersion)
+	require.Nil(t, got.RollbackOf)
+	require.Equal(t, []AllowanceChange{{AllowanceType: "personal", OldAmount: 60000, NewAmount: 70000}}, got.Changes)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyAllowanceAmountWithoutSettingsRow(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM tax_years WHERE is_current FOR UPDATE`)).WillReturnError(sql.ErrNoRows)
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+
+	_, err = store.applyAllowanceAmount(context.Background(), tx, "personal", 70000, 1, "adminTax", "")
+
+	require.EqualError(t, err, "no record found with the specified id")
+}
+
+func TestApplyAllowanceAmountWhenInsertFails(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectAllowanceSettingsLocked(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO allowance_versions`)).WillReturnError(sql.ErrConnDone)
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+
+	_, err = store.applyAllowanceAmount(context.Background(), tx, "k-receipt", 70000, anyVersion, "adminTax", "")
+
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyAllowanceAmountWithStaleVersion(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectAllowanceSettingsLocked(mock, 2)
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+
+	_, err = store.applyAllowanceAmount(context.Background(), tx, "personal", 70000, 1, "adminTax", "")
+
+	var changed *SettingsChangedError
+	require.ErrorAs(t, err, &changed)
+	require.Equal(t, SettingsSnapshot{
+		Version:    2,
+		Allowances: AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 50000},
+	}, changed.Current)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyRollback(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectAllowanceSettingsLocked(mock, 3)
+	expectAllowanceCaps(mock, 2, AllowanceSettings{Personal: 80000, Donation: 100000, KReceipt: 50000})
+	expectAllowanceVersionSaved(mock, AllowanceSettings{Personal: 80000, Donation: 100000, KReceipt: 50000}, "adminTax", 3, 2, 4)
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+
+	got, err := store.applyRollback(context.Background(), tx, 2, 3, "adminTax", "")
+
+	require.NoError(t, err)
+	require.Equal(t, int64(4), got.Version)
+	require.Equal(t, int64(2), *got.RollbackOf)
+	require.Equal(t, []AllowanceChange{{AllowanceType: "personal", OldAmount: 60000, NewAmount: 80000}}, got.Changes)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyRollbackToActiveVersion(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectAllowanceSettingsLocked(mock, 3)
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+
+	_, err = store.applyRollback(context.Background(), tx, 3, 3, "adminTax", "")
+
+	require.ErrorIs(t, err, ErrVersionAlreadyActive)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyRollbackToUnknownVersion(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectBegin()
+	expectAllowanceSettingsLocked(mock, 3)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT deduction_type, amount FROM allowance_caps WHERE version_id = $1`)).
+		WithArgs(99).WillReturnRows(mock.NewRows([]string{"deduction_type", "amount"}))
+
+	tx, err := store.db.Begin()
+	require.NoError(t, err)
+
+	_, err = store.applyRollback(context.Background(), tx, 99, anyVersion, "adminTax", "")
+
+	require.ErrorIs(t, err, ErrVersionNotFound)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllowanceVersions(t *testing.T) {
+	store, mock := setupMockStore()
+
+	changedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rows := mock.NewRows(allowanceVersionColumns)
+	addAllowanceVersionRows(rows, 2, AllowanceSettings{Personal: 70000, Donation: 100000, KReceipt: 50000}, "adminTax", changedAt, "raise personal", 1, nil)
+	addAllowanceVersionRows(rows, 1, AllowanceSettings{Personal: 60000, Donation: 100000, KReceipt: 50000}, "system", changedAt, "initial settings", nil, nil)
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " ORDER BY allowance_versions.id DESC")).WillReturnRows(rows)
+
+	got, err := store.ListVersions(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, int64(2), got[0].Version)
+	require.Equal(t, int64(1), *got[0].PreviousVersion)
+	require.Nil(t, got[1].PreviousVersion)
+	require.Equal(t, "system", got[1].ChangedBy)
+}
+
+func TestGetAllowanceVersionNotFound(t *testing.T) {
+	store, mock := setupMockStore()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions + " WHERE allowance_versions.id = $1")).WithArgs(5).WillReturnRows(mock.NewRows(allowanceVersionColumns))
+
+	_, err := store.GetVersion(context.Background(), 5)
+
+	require.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func expectAllowanceSettingsLocked(mock sqlmock.Sqlmock, version int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM tax_years WHERE is_current FOR UPDATE`)).
+		WillReturnRows(mock.NewRows([]string{"version"}).AddRow(version))
+	expectAllowanceCaps(mock, version, DefaultAllowanceSettings)
+}
+
+func expectAllowanceCaps(mock sqlmock.Sqlmock, version int64, settings AllowanceSettings) {
+	rows := mock.NewRows([]string{"deduction_type", "amount"})
+	for _, allowanceType := range deductionTypes {
+		rows.AddRow(allowanceType, settings.get(allowanceType))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT deduction_type, amount FROM allowance_caps WHERE version_id = $1`)).
+		WithArgs(version).WillReturnRows(rows)
+}
+
+func expectAllowanceVersionSaved(mock sqlmock.Sqlmock, settings AllowanceSettings, changedBy string, previousVersion int64, rollbackOf any, newVersion int64) {
+	rows := mock.NewRows([]string{"id", "changed_at"}).AddRow(newVersion, time.Now())
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO allowance_versions (changed_by, reason, previous_version, rollback_of) VALUES ($1, $2, $3, $4) RETURNING id, changed_at`)).
+		WithArgs(changedBy, sqlmock.AnyArg(), previousVersion, rollbackOf).
+		WillReturnRows(rows)
+	for _, allowanceType := range deductionTypes {
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO allowance_caps (version_id, deduction_type, amount) VALUES ($1, $2, $3)`)).
+			WithArgs(newVersion, allowanceType, settings.get(allowanceType)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	source := any(previousVersion)
+	if rollbackOf != nil {
+		source = rollbackOf
+	}
+	mock.ExpectExec(regexp.QuoteMeta(carryOverAllowanceCaps)).
+		WithArgs(newVersion, source).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE tax_years SET version = $1 WHERE is_current`)).
+		WithArgs(newVersion).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(settingsChangedChannel, strconv.FormatInt(newVersion, 10)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func addAllowanceVersionRows(rows *sqlmock.Rows, version int64, settings AllowanceSettings, changedBy string, changedAt time.Time, reason string, previousVersion, rollbackOf any) {
+	for _, allowanceType := range deductionTypes {
+		rows.AddRow(version, changedBy, changedAt, reason, previousVersion, rollbackOf, allowanceType, settings.get(allowanceType))
+	}
+}
@@ -0,0 +1,41 @@
+package tax
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnvInt reads a number that is not less than 0 from name, defaultValue
+// when name is not set. The server does not start with an invalid value.
+func GetEnvInt(name string, defaultValue int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		log.Fatalf("%s should be a number that is not less than 0: %q", name, value)
+	}
+
+	return number
+}
+
+// GetEnvDuration reads a positive duration such as 30s from name,
+// defaultValue when name is not set. The server does not start with an
+// invalid value.
+func GetEnvDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Fatalf("%s should be a positive duration: %q", name, value)
+	}
+
+	return duration
+}
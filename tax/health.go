@@ -0,0 +1,71 @@
+package tax
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const readinessCheckTimeout = 2 * time.Second
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (h *Handler) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	h.readiness = append(h.readiness, readinessCheck{name: name, check: check})
+}
+
+func (h *Handler) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, HealthResponse{Status: healthStatusOK})
+}
+
+func (h *Handler) Readyz(c echo.Context) error {
+	response := HealthResponse{
+		Status: healthStatusOK,
+		Checks: map[string]DependencyStatus{},
+	}
+
+	checks := append([]readinessCheck{{name: "settings", check: h.checkSettingsLoadable}}, h.readiness...)
+
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), readinessCheckTimeout)
+		err := check.check(ctx)
+		cancel()
+
+		if err != nil {
+			log.Printf("Readiness check %s failed: %v", check.name, err)
+			response.Status = healthStatusUnavailable
+			response.Checks[check.name] = DependencyStatus{Status: healthStatusUnavailable, Error: err.Error()}
+			continue
+		}
+
+		response.Checks[check.name] = DependencyStatus{Status: healthStatusOK}
+	}
+
+	if response.Status != healthStatusOK {
+		return c.JSON(http.StatusServiceUnavailable, response)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+type settingsRefresher interface {
+	Refresh(ctx context.Context) (SettingsSnapshot, error)
+}
+
+// checkSettingsLoadable reads the settings from the store, a cached snapshot
+// would report ok while the database is down.
+func (h *Handler) checkSettingsLoadable(ctx context.Context) error {
+	if settings, ok := h.settings.(settingsRefresher); ok {
+		_, err := settings.Refresh(ctx)
+		return err
+	}
+
+	_, err := h.settings.LoadSettings(ctx)
+	return err
+}
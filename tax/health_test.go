@@ -0,0 +1,117 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthz(t *testing.T) {
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/healthz", "")
+
+	err := h.Healthz(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"status": "ok"}`, rec.Body.String())
+}
+
+func TestReadyzWhenAllDependenciesAreReady(t *testing.T) {
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+	h.AddReadinessCheck("database", func(ctx context.Context) error { return nil })
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/readyz", "")
+
+	err := h.Readyz(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"status": "ok", "checks": {
+		"settings": {"status": "ok"},
+		"database": {"status": "ok"}
+	}}`, rec.Body.String())
+}
+
+func TestReadyzWhenDependencyFails(t *testing.T) {
+	h, mock := setupMockHandler()
+	h.AddReadinessCheck("migrations", func(ctx context.Context) error {
+		return &SchemaVersionError{Current: 1, Expected: 2}
+	})
+
+	expectSettingsSnapshot(mock, 1)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/readyz", "")
+
+	err := h.Readyz(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.JSONEq(t, `{"status": "unavailable", "checks": {
+		"settings": {"status": "ok"},
+		"migrations": {"status": "unavailable", "error": "database schema version is 1 but the server expects 2, run the migrate command"}
+	}}`, rec.Body.String())
+}
+
+func TestReadyzWhenSettingsAreCachedButDatabaseIsDown(t *testing.T) {
+	store, mock := setupMockStore()
+	settings := NewSettingsCache(store, time.Hour)
+	h := NewHandler(NewReadReplica(settings, NewSettingsCache(NewMemoryStore(DefaultAllowanceSettings), time.Hour)), store)
+
+	expectSettingsSnapshot(mock, 1)
+	_, err := settings.Refresh(context.Background())
+	require.NoError(t, err)
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).WillReturnError(sql.ErrConnDone)
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/readyz", "")
+
+	err = h.Readyz(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.JSONEq(t, `{"status": "unavailable", "checks": {
+		"settings": {"status": "unavailable", "error": "database is unavailable, please retry later"}
+	}}`, rec.Body.String())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPingWithRetrySucceedsAfterFailures(t *testing.T) {
+	db := &flakyPinger{failures: 2}
+
+	err := pingWithRetry(context.Background(), db, 3, time.Millisecond)
+
+	require.NoError(t, err)
+	require.Equal(t, 3, db.pings)
+}
+
+func TestPingWithRetryGivesUp(t *testing.T) {
+	db := &flakyPinger{failures: 10}
+
+	err := pingWithRetry(context.Background(), db, 3, time.Millisecond)
+
+	require.Error(t, err)
+	require.Equal(t, 3, db.pings)
+}
+
+type flakyPinger struct {
+	failures int
+	pings    int
+}
+
+func (p *flakyPinger) PingContext(ctx context.Context) error {
+	p.pings++
+	if p.pings <= p.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// jobSlots is how many batch jobs are calculated at the same time.
+	jobSlots = 2
+
+	jobProgressEvery = 500
+	// jobStaleAfter is how long a running job may go without a heartbeat
+	// before it is taken as abandoned by a stopped server and run again.
+	jobStaleAfter = time.Minute
+	// jobHeartbeats is how many heartbeats a running job writes within
+	// jobStaleAfter.
+	jobHeartbeats = 4
+)
+
+const (
+	// DefaultJobMaxUploadBytes is the largest upload kept for a batch job.
+	DefaultJobMaxUploadBytes int64 = 32 << 20
+	// DefaultJobRetention is how long a finished job and its result are kept.
+	DefaultJobRetention = 7 * 24 * time.Hour
+)
+
+var errJobsDisabled = errors.New("batch jobs are not available")
+
+type jobLimits struct {
+	maxUploadBytes int64
+	retention      time.Duration
+}
+
+// UseJobLimits sets the largest upload a batch job accepts and how long
+// finished jobs are kept, a value of 0 keeps the default. It has to be called
+// before StartJobs.
+func (h *Handler) UseJobLimits(maxUploadBytes int64, retention time.Duration) {
+	if maxUploadBytes > 0 {
+		h.jobLimits.maxUploadBytes = maxUploadBytes
+	}
+	if retention > 0 {
+		h.jobLimits.retention = retention
+	}
+}
+
+// jobRunner calculates batch jobs in the background, a few at a time.
+type jobRunner struct {
+	ctx      context.Context
+	store    JobStore
+	settings SettingsLoader
+	rows     *rowPool
+	limits   jobLimits
+	slots    chan struct{}
+
+	staleAfter time.Duration
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// StartJobs runs batch jobs kept in store until ctx is done. Jobs left queued
+// or running by a previous server are picked up again.
+func (h *Handler) StartJobs(ctx context.Context, store JobStore) {
+	h.jobs = &jobRunner{
+		ctx:      ctx,
+		store:    store,
+		settings: h.calculations,
+		rows:     h.rows,
+		limits:   h.jobLimits,
+		slots:    make(chan struct{}, jobSlots),
+		cancels:  map[string]context.CancelFunc{},
+
+		staleAfter: jobStaleAfter,
+	}
+
+	go h.jobs.resumeEvery(jobStaleAfter)
+}
+
+func (r *jobRunner) resumeEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.resume()
+		r.deleteExpired()
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *jobRunner) resume() {
+	ids, err := r.store.ListResumableJobs(r.ctx, time.Now().Add(-r.staleAfter))
+	if err != nil {
+		log.Printf("Cannot list batch jobs to resume: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		r.submit(id)
+	}
+}
+
+func (r *jobRunner) deleteExpired() {
+	deleted, err := r.store.DeleteFinishedJobs(r.ctx, time.Now().Add(-r.limits.retention))
+	if err != nil {
+		log.Printf("Cannot delete expired batch jobs: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Deleted %d batch jobs finished more than %s ago", deleted, r.limits.retention)
+	}
+}
+
+func (r *jobRunner) submit(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.cancels[id]; ok {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.cancels[id] = cancel
+
+	go func() {
+		defer r.done(id)
+		defer r.recoverJob(ctx, id)
+
+		select {
+		case r.slots <- struct{}{}:
+			defer func() { <-r.slots }()
+		case <-ctx.Done():
+			return
+		}
+
+		if err := r.run(ctx, id); err != nil {
+			log.Printf("Batch job %s stopped: %v", id, err)
+		}
+	}()
+}
+
+// recoverJob marks a job failed when calculating it panics, so one bad upload
+// does not stop the server.
+func (r *jobRunner) recoverJob(ctx context.Context, id string) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	log.Printf("Batch job %s panicked: %v\n%s", id, p, debug.Stack())
+	if err := r.store.FinishJob(ctx, id, jobStatusFailed, "unexpected error while calculating the job", nil); err != nil {
+		log.Printf("Cannot mark batch job %s as failed: %v", id, err)
+	}
+}
+
+func (r *jobRunner) done(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancel, ok := r.cancels[id]; ok {
+		cancel()
+		delete(r.cancels, id)
+	}
+}
+
+func (r *jobRunner) cancel(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cancel, ok := r.cancels[id]; ok {
+		cancel()
+	}
+}
+
+// run calculates a job and stores its result. It returns without finishing
+// the job when ctx is done, a cancelled job is already marked in the store
+// and a job stopped by shutdown is resumed once it goes stale.
+func (r *jobRunner) run(ctx context.Context, id string) error {
+	claimed, err := r.store.ClaimJob(ctx, id, time.Now().Add(-r.staleAfter))
+	if err != nil || !claimed {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.heartbeat(ctx, cancel, id)
+
+	job, err := r.store.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	input, err := r.store.GetJobInput(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.calculate(ctx, job, input)
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return context.Canceled
+	}
+
+	var rowErr *CSVRowError
+	switch {
+	case errors.As(err, &rowErr):
+		return r.store.FinishJob(ctx, id, jobStatusFailed, fmt.Sprintf("line %d: %s", rowErr.Line, rowErr.Reason), nil)
+	case err != nil:
+		return r.store.FinishJob(ctx, id, jobStatusFailed, err.Error(), nil)
+	}
+
+	content, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return r.store.FinishJob(ctx, id, jobStatusSucceeded, "", content)
+}
+
+// heartbeat keeps a claimed job from going stale while it runs, also through
+// the parts that report no row progress such as counting the rows, so
+// another server does not claim it again. It cancels the job once it is no
+// longer running in the store.
+func (r *jobRunner) heartbeat(ctx context.Context, cancel context.CancelFunc, id string) {
+	ticker := time.NewTicker(r.staleAfter / jobHeartbeats)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		running, err := r.store.TouchJob(ctx, id)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Cannot write heartbeat of batch job %s: %v", id, err)
+			}
+			continue
+		}
+		if !running {
+			// cancelled by another server sharing the store
+			cancel()
+			return
+		}
+	}
+}
+
+func (r *jobRunner) calculate(ctx context.Context, job BatchJob, input []byte) (TaxResponseCSV, error) {
+	totalRows, err := countTaxRows(input, job.Filename, job.Sheet)
+	if err != nil {
+		return TaxResponseCSV{}, err
+	}
+
+	reader, header, err := openTaxRows(bytes.NewReader(input), job.Filename, job.Sheet)
+	if err != nil {
+		return TaxResponseCSV{}, err
+	}
+
+	columns, headerErr := mapTaxCSVHeader(header)
+	if headerErr != nil {
+		return TaxResponseCSV{}, errors.New(headerErr.Message)
+	}
+
+	settings, err := r.settings.LoadSettings(ctx)
+	if err != nil {
+		return TaxResponseCSV{}, err
+	}
+
+	batch := &taxCSVBatch{reader: reader, header: header, columns: columns, mode: job.Mode, settings: settings.Allowances, detail: job.Detail, pool: r.rows}
+
+	processedRows, invalidRows := 0, 0
+	updateProgress := func() error {
+		running, err := r.store.UpdateJobProgress(ctx, job.ID, totalRows, processedRows, invalidRows)
+		if err == nil && !running {
+			// cancelled by another server sharing the store
+			return context.Canceled
+		}
+		return err
+	}
+
+	if err := updateProgress(); err != nil {
+		return TaxResponseCSV{}, err
+	}
+
+	result, err := collectTaxResponseCSV(ctx, batch, settings.Version, func(row taxCSVRow) error {
+		processedRows++
+		if row.err != nil {
+			invalidRows++
+		}
+
+		if processedRows%jobProgressEvery == 0 {
+			return updateProgress()
+		}
+		return nil
+	})
+	if err != nil {
+		return TaxResponseCSV{}, err
+	}
+
+	return result, updateProgress()
+}
+
+// countTaxRows reads the upload once ahead of the calculation so the progress
+// of a job can be given against its total.
+func countTaxRows(input []byte, filename, sheet string) (int, error) {
+	reader, _, err := openTaxRows(bytes.NewReader(input), filename, sheet)
+	if err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return 0, err
+		}
+		rows++
+	}
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// submitTaxJob keeps the upload as a job and answers before any row is
+// calculated. The header is still checked up front so a wrong file is
+// rejected right away.
+func (h *Handler) submitTaxJob(c echo.Context, src io.Reader, filename, mode string, detail bool) error {
+	if h.jobs == nil {
+		return c.String(http.StatusServiceUnavailable, errJobsDisabled.Error())
+	}
+
+	input, err := io.ReadAll(io.LimitReader(src, h.jobs.limits.maxUploadBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(input)) > h.jobs.limits.maxUploadBytes {
+		return c.String(http.StatusRequestEntityTooLarge, fmt.Sprintf("upload should not be larger than %d bytes", h.jobs.limits.maxUploadBytes))
+	}
+
+	sheet := c.QueryParam("sheet")
+
+	_, header, err := openTaxRows(bytes.NewReader(input), filename, sheet)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	if _, headerErr := mapTaxCSVHeader(header); headerErr != nil {
+		return c.JSON(http.StatusBadRequest, headerErr)
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return err
+	}
+
+	job, err := h.jobs.store.CreateJob(c.Request().Context(), BatchJob{ID: id, Filename: filename, Mode: mode, Sheet: sheet, Detail: detail}, input)
+	if err != nil {
+		return storeErrorResponse(c, err)
+	}
+
+	h.jobs.submit(job.ID)
+
+	c.Response().Header().Set(echo.HeaderLocation, "/tax/jobs/"+job.ID)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func (h *Handler) GetTaxJob(c echo.Context) error {
+	if h.jobs == nil {
+		return c.String(http.StatusServiceUnavailable, errJobsDisabled.Error())
+	}
+
+	job, err := h.jobs.store.GetJob(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return jobErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) GetTaxJobResult(c echo.Context) error {
+	if h.jobs == nil {
+		return c.String(http.StatusServiceUnavailable, errJobsDisabled.Error())
+	}
+
+	job, err := h.jobs.store.GetJob(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return jobErrorResponse(c, err)
+	}
+
+	if job.Status != jobStatusSucceeded {
+		return c.String(http.StatusConflict, fmt.Sprintf("job is %s", job.Status))
+	}
+
+	result, err := h.jobs.store.GetJobResult(c.Request().Context(), job.ID)
+	if err != nil {
+		return jobErrorResponse(c, err)
+	}
+
+	return c.JSONBlob(http.StatusOK, result)
+}
+
+func (h *Handler) CancelTaxJob(c echo.Context) error {
+	if h.jobs == nil {
+		return c.String(http.StatusServiceUnavailable, errJobsDisabled.Error())
+	}
+
+	job, err := h.jobs.store.CancelJob(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return jobErrorResponse(c, err)
+	}
+
+	h.jobs.cancel(job.ID)
+
+	return c.JSON(http.StatusOK, job)
+}
+
+func jobErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrJobFinished):
+		return c.String(http.StatusConflict, err.Error())
+	}
+	return storeErrorResponse(c, err)
+}
@@ -0,0 +1,336 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func setupJobHandler(t *testing.T) (*Handler, *MemoryStore) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+	h.StartJobs(ctx, store)
+
+	return h, store
+}
+
+func submitTaxJob(t *testing.T, h *Handler, url, content string) BatchJob {
+	t.Helper()
+
+	rec, c := mockNewRequestCSVWithURL(t, url, content)
+
+	require.NoError(t, h.CalculateTaxWithCSV(c))
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	var job BatchJob
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	require.Equal(t, "/tax/jobs/"+job.ID, rec.Header().Get(echo.HeaderLocation))
+
+	return job
+}
+
+func waitForTaxJob(t *testing.T, store JobStore, id string) BatchJob {
+	t.Helper()
+
+	var job BatchJob
+	require.Eventually(t, func() bool {
+		var err error
+		job, err = store.GetJob(context.Background(), id)
+		require.NoError(t, err)
+		return job.finished()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return job
+}
+
+func mockNewJobRequest(method, id string) (*httptest.ResponseRecorder, echo.Context) {
+	e := echo.New()
+	req := httptest.NewRequest(method, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return rec, c
+}
+
+func TestCalculateTaxWithCSVAsync(t *testing.T) {
+	h, store := setupJobHandler(t)
+
+	job := submitTaxJob(t, h, "/tax/calculations/upload-csv?async=true&mode=report", "totalIncome,wht,donation\n500000,0,0\n600000,abc,20000\n600000,40000,20000\n")
+	require.Equal(t, jobStatusQueued, job.Status)
+	require.Equal(t, csvModeReport, job.Mode)
+
+	job = waitForTaxJob(t, store, job.ID)
+	require.Equal(t, jobStatusSucceeded, job.Status)
+	require.Equal(t, 3, job.TotalRows)
+	require.Equal(t, 3, job.ProcessedRows)
+	require.Equal(t, 1, job.InvalidRows)
+
+	rec, c := mockNewJobRequest(http.MethodGet, job.ID)
+	require.NoError(t, h.GetTaxJob(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec, c = mockNewJobRequest(http.MethodGet, job.ID)
+	require.NoError(t, h.GetTaxJobResult(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var result TaxResponseCSV
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, []TaxCSV{
+		{TotalIncome: 500000, Tax: 29000},
+		{TotalIncome: 600000, TaxRefund: 2000},
+	}, result.Taxes)
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, 3, result.Errors[0].Line)
+}
+
+func TestCalculateTaxWithCSVAsyncWithBreakdown(t *testing.T) {
+	h, store := setupJobHandler(t)
+
+	job := submitTaxJob(t, h, "/tax/calculations/upload-csv?async=true&detail=true", "totalIncome\n500000\n")
+	require.True(t, job.Detail)
+	require.Equal(t, jobStatusSucceeded, waitForTaxJob(t, store, job.ID).Status)
+
+	result, err := store.GetJobResult(context.Background(), job.ID)
+	require.NoError(t, err)
+
+	var response TaxResponseCSV
+	require.NoError(t, json.Unmarshal(result, &response))
+	require.Len(t, response.Taxes, 1)
+	require.NotNil(t, response.Taxes[0].Breakdown)
+	require.Equal(t, 440000.0, response.Taxes[0].Breakdown.NetIncome)
+}
+
+func TestCalculateTaxWithCSVAsyncFailsOnInvalidRowInStrictMode(t *testing.T) {
+	h, store := setupJobHandler(t)
+
+	job := submitTaxJob(t, h, "/tax/calculations/upload-csv?async=true", "totalIncome,wht\n500000,0\n600000,abc\n")
+
+	job = waitForTaxJob(t, store, job.ID)
+	require.Equal(t, jobStatusFailed, job.Status)
+	require.Equal(t, "line 3: cannot parse str to float64", job.Error)
+
+	rec, c := mockNewJobRequest(http.MethodGet, job.ID)
+	require.NoError(t, h.GetTaxJobResult(c))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, "job is failed", rec.Body.String())
+}
+
+func TestCalculateTaxWithCSVAsyncRejectsInvalidHeader(t *testing.T) {
+	h, _ := setupJobHandler(t)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?async=true", "income,salary\n500000,1\n")
+
+	require.NoError(t, h.CalculateTaxWithCSV(c))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	require.JSONEq(t, `{"message":"invalid csv header","unknownColumns":["salary"]}`, rec.Body.String())
+}
+
+func TestCalculateTaxWithCSVAsyncWithoutJobs(t *testing.T) {
+	h := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?async=true", "totalIncome\n500000\n")
+
+	require.NoError(t, h.CalculateTaxWithCSV(c))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestCalculateTaxWithCSVAsyncRejectsLargeUpload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+	h.UseJobLimits(16, 0)
+	h.StartJobs(ctx, store)
+
+	rec, c := mockNewRequestCSVWithURL(t, "/tax/calculations/upload-csv?async=true", "totalIncome\n500000\n600000\n")
+
+	require.NoError(t, h.CalculateTaxWithCSV(c))
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Equal(t, "upload should not be larger than 16 bytes", rec.Body.String())
+	require.Empty(t, store.jobs)
+}
+
+func TestStartJobsDeletesExpiredJobs(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	for _, id := range []string{"expired", "recent", "queued"} {
+		_, err := store.CreateJob(ctx, BatchJob{ID: id, Filename: "taxes.csv", Mode: csvModeStrict}, []byte("totalIncome\n500000\n"))
+		require.NoError(t, err)
+	}
+	for _, id := range []string{"expired", "recent"} {
+		claimed, err := store.ClaimJob(ctx, id, time.Now())
+		require.NoError(t, err)
+		require.True(t, claimed)
+		require.NoError(t, store.FinishJob(ctx, id, jobStatusSucceeded, "", []byte(`{}`)))
+	}
+	finishedAt := time.Now().Add(-2 * time.Hour)
+	store.jobs["expired"].job.FinishedAt = &finishedAt
+
+	h := NewHandler(store, store)
+	h.UseJobLimits(0, time.Hour)
+	jobsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h.StartJobs(jobsCtx, store)
+
+	require.Eventually(t, func() bool {
+		_, err := store.GetJob(ctx, "expired")
+		return errors.Is(err, ErrJobNotFound)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err := store.GetJob(ctx, "recent")
+	require.NoError(t, err)
+	require.Equal(t, jobStatusSucceeded, waitForTaxJob(t, store, "queued").Status)
+}
+
+func TestStartJobsFailsJobThatPanics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+	h.UseCalculationSettings(panickingSettingsLoader{})
+	h.StartJobs(ctx, store)
+
+	job := submitTaxJob(t, h, "/tax/calculations/upload-csv?async=true", "totalIncome\n500000\n")
+
+	finished := waitForTaxJob(t, store, job.ID)
+	require.Equal(t, jobStatusFailed, finished.Status)
+	require.Equal(t, "unexpected error while calculating the job", finished.Error)
+
+	// the runner keeps calculating other jobs
+	h.jobs.settings = store
+	job = submitTaxJob(t, h, "/tax/calculations/upload-csv?async=true", "totalIncome\n500000\n")
+	require.Equal(t, jobStatusSucceeded, waitForTaxJob(t, store, job.ID).Status)
+}
+
+type panickingSettingsLoader struct{}
+
+func (panickingSettingsLoader) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	panic("settings are broken")
+}
+
+func TestRunningJobIsNotClaimedAgainWhileSlowToStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := &claimCountingJobStore{MemoryStore: NewMemoryStore(DefaultAllowanceSettings)}
+	_, err := store.CreateJob(ctx, BatchJob{ID: "slow", Filename: "taxes.csv", Mode: csvModeStrict}, []byte("totalIncome\n500000\n"))
+	require.NoError(t, err)
+
+	newRunner := func(settings SettingsLoader) *jobRunner {
+		return &jobRunner{ctx: ctx, store: store, settings: settings, rows: newRowPool(0, 0), slots: make(chan struct{}, jobSlots), cancels: map[string]context.CancelFunc{}, staleAfter: 40 * time.Millisecond}
+	}
+
+	// the first server takes longer than the stale window before its first
+	// progress, as counting the rows of a large upload does
+	first := newRunner(slowSettingsLoader{SettingsLoader: store, delay: 300 * time.Millisecond})
+	first.submit("slow")
+	require.Eventually(t, func() bool { return store.claims.Load() == 1 }, time.Second, time.Millisecond)
+
+	second := newRunner(store)
+	for deadline := time.Now().Add(200 * time.Millisecond); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		second.resume()
+	}
+
+	require.Equal(t, jobStatusSucceeded, waitForTaxJob(t, store, "slow").Status)
+	require.Equal(t, int32(1), store.claims.Load())
+}
+
+type claimCountingJobStore struct {
+	*MemoryStore
+	claims atomic.Int32
+}
+
+func (s *claimCountingJobStore) ClaimJob(ctx context.Context, id string, staleBefore time.Time) (bool, error) {
+	claimed, err := s.MemoryStore.ClaimJob(ctx, id, staleBefore)
+	if claimed {
+		s.claims.Add(1)
+	}
+	return claimed, err
+}
+
+type slowSettingsLoader struct {
+	SettingsLoader
+	delay time.Duration
+}
+
+func (s slowSettingsLoader) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	time.Sleep(s.delay)
+	return s.SettingsLoader.LoadSettings(ctx)
+}
+
+func TestCancelTaxJob(t *testing.T) {
+	h, store := setupJobHandler(t)
+
+	// keep every slot busy so the job stays queued
+	for i := 0; i < jobSlots; i++ {
+		h.jobs.slots <- struct{}{}
+	}
+
+	job := submitTaxJob(t, h, "/tax/calculations/upload-csv?async=true", "totalIncome\n500000\n")
+
+	rec, c := mockNewJobRequest(http.MethodPost, job.ID)
+	require.NoError(t, h.CancelTaxJob(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	job, err := store.GetJob(context.Background(), job.ID)
+	require.NoError(t, err)
+	require.Equal(t, jobStatusCancelled, job.Status)
+
+	rec, c = mockNewJobRequest(http.MethodPost, job.ID)
+	require.NoError(t, h.CancelTaxJob(c))
+	require.Equal(t, http.StatusConflict, rec.Code)
+
+	rec, c = mockNewJobRequest(http.MethodGet, job.ID)
+	require.NoError(t, h.GetTaxJobResult(c))
+	require.Equal(t, http.StatusConflict, rec.Code)
+	require.Equal(t, "job is cancelled", rec.Body.String())
+}
+
+func TestGetTaxJobNotFound(t *testing.T) {
+	h, _ := setupJobHandler(t)
+
+	rec, c := mockNewJobRequest(http.MethodGet, "missing")
+
+	require.NoError(t, h.GetTaxJob(c))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestStartJobsResumesUnfinishedJobs(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	_, err := store.CreateJob(ctx, BatchJob{ID: "queued", Filename: "taxes.csv", Mode: csvModeStrict}, []byte("totalIncome\n500000\n"))
+	require.NoError(t, err)
+
+	_, err = store.CreateJob(ctx, BatchJob{ID: "abandoned", Filename: "taxes.csv", Mode: csvModeStrict}, []byte("totalIncome\n600000\n"))
+	require.NoError(t, err)
+	claimed, err := store.ClaimJob(ctx, "abandoned", time.Now())
+	require.NoError(t, err)
+	require.True(t, claimed)
+	store.jobs["abandoned"].updatedAt = time.Now().Add(-2 * jobStaleAfter)
+
+	h := NewHandler(store, store)
+	jobsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h.StartJobs(jobsCtx, store)
+
+	require.Equal(t, jobStatusSucceeded, waitForTaxJob(t, store, "queued").Status)
+	require.Equal(t, jobStatusSucceeded, waitForTaxJob(t, store, "abandoned").Status)
+}
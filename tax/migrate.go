@@ -0,0 +1,314 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID serializes migrations when several replicas start at once
+const migrationLockID = 7_264_173_519
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type SchemaVersionError struct {
+	Current  int64
+	Expected int64
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("database schema version is %d but the server expects %d, run the migrate command", e.Current, e.Expected)
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator loads the migrations written for dialect, each dialect keeps
+// its own copy under migrations/<dialect> with the same versions and names.
+func NewMigrator(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", string(dialect)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+func (m *Migrator) LatestVersion() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version reads the applied schema version without changing the database, so
+// readiness probes can call it. A database without the schema_version table
+// is at version 0.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	exists, err := m.schemaVersionTableExists(ctx)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	return currentSchemaVersion(ctx, m.db)
+}
+
+func (m *Migrator) CheckVersion(ctx context.Context) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current != m.LatestVersion() {
+		return &SchemaVersionError{Current: current, Expected: m.LatestVersion()}
+	}
+
+	return nil
+}
+
+func (m *Migrator) Up(ctx context.Context) (int64, error) {
+	if err := m.createSchemaVersionTable(ctx); err != nil {
+		return 0, err
+	}
+
+	for _, migration := range m.migrations {
+		_, err := m.apply(ctx, func(tx *sql.Tx, current int64) (bool, error) {
+			if migration.Version <= current {
+				return false, nil
+			}
+
+			if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+				return false, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+
+			_, err := tx.ExecContext(ctx, `INSERT INTO schema_version (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			return true, err
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return currentSchemaVersion(ctx, m.db)
+}
+
+func (m *Migrator) Down(ctx context.Context, steps int) (int64, error) {
+	if err := m.createSchemaVersionTable(ctx); err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < steps; i++ {
+		reverted, err := m.apply(ctx, func(tx *sql.Tx, current int64) (bool, error) {
+			if current == 0 {
+				return false, nil
+			}
+
+			migration, ok := m.migration(current)
+			if !ok {
+				return false, fmt.Errorf("no migration found for schema version %d", current)
+			}
+
+			if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+				return false, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+
+			_, err := tx.ExecContext(ctx, `DELETE FROM schema_version WHERE version = $1`, migration.Version)
+			return true, err
+		})
+		if err != nil {
+			return 0, err
+		}
+		if !reverted {
+			break
+		}
+	}
+
+	return currentSchemaVersion(ctx, m.db)
+}
+
+// Force records version as applied without running any SQL, for databases
+// whose schema was created before migrations were tracked.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	if version != 0 {
+		if _, ok := m.migration(version); !ok {
+			return fmt.Errorf("no migration found for schema version %d", version)
+		}
+	}
+
+	if err := m.createSchemaVersionTable(ctx); err != nil {
+		return err
+	}
+
+	_, err := m.apply(ctx, func(tx *sql.Tx, current int64) (bool, error) {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM schema_version`); err != nil {
+			return false, err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO schema_version (version, name) VALUES ($1, $2)`, migration.Version, migration.Name); err != nil {
+				return false, err
+			}
+		}
+
+		return true, nil
+	})
+
+	return err
+}
+
+func (m *Migrator) apply(ctx context.Context, step func(tx *sql.Tx, current int64) (bool, error)) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// SQLite allows a single writer, so only Postgres needs the advisory lock
+	if m.dialect != DialectSQLite {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+			return false, err
+		}
+	}
+
+	current, err := currentSchemaVersion(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := step(tx, current)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (m *Migrator) createSchemaVersionTable(ctx context.Context) error {
+	query := `CREATE TABLE IF NOT EXISTS schema_version (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamptz NOT NULL DEFAULT now())`
+	if m.dialect == DialectSQLite {
+		query = `CREATE TABLE IF NOT EXISTS schema_version (version bigint PRIMARY KEY, name text NOT NULL, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)`
+	}
+
+	_, err := m.db.ExecContext(ctx, query)
+	return err
+}
+
+func (m *Migrator) schemaVersionTableExists(ctx context.Context) (bool, error) {
+	query := `SELECT to_regclass('schema_version') IS NOT NULL`
+	if m.dialect == DialectSQLite {
+		query = `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version')`
+	}
+
+	var exists bool
+	err := m.db.QueryRowContext(ctx, query).Scan(&exists)
+	return exists, err
+}
+
+func (m *Migrator) migration(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func currentSchemaVersion(ctx context.Context, db queryRower) (int64, error) {
+	var version int64
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+
+	for _, name := range names {
+		base := path.Base(name)
+
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s should end with .up.sql or .down.sql", base)
+		}
+
+		prefix, migrationName, ok := strings.Cut(strings.TrimSuffix(base, "."+direction+".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s should be named <version>_<name>", base)
+		}
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s should start with a positive version", base)
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: migrationName}
+			byVersion[version] = migration
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d %s should have both up and down files", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, migration := range migrations {
+		if migration.Version != int64(i+1) {
+			return nil, fmt.Errorf("migration versions should be sequential, missing version %d", i+1)
+		}
+	}
+
+	return migrations, nil
+}
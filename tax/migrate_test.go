@@ -0,0 +1,183 @@
+package tax
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	for _, dialect := range []Dialect{DialectPostgres, DialectSQLite} {
+		migrations, err := loadMigrations(migrationFiles, "migrations/"+string(dialect))
+
+		require.NoError(t, err, dialect)
+		require.NotEmpty(t, migrations, dialect)
+		require.Equal(t, "create_allowances", migrations[0].Name)
+		for i, migration := range migrations {
+			require.Equal(t, int64(i+1), migration.Version)
+			require.NotEmpty(t, migration.Up)
+			require.NotEmpty(t, migration.Down)
+		}
+	}
+}
+
+func TestEmbeddedMigrationsMatchAcrossDialects(t *testing.T) {
+	postgres, err := loadMigrations(migrationFiles, "migrations/postgres")
+	require.NoError(t, err)
+	sqlite, err := loadMigrations(migrationFiles, "migrations/sqlite")
+	require.NoError(t, err)
+
+	require.Len(t, sqlite, len(postgres))
+	for i := range postgres {
+		require.Equal(t, postgres[i].Version, sqlite[i].Version)
+		require.Equal(t, postgres[i].Name, sqlite[i].Name)
+	}
+}
+
+func TestLoadMigrationsWithInvalidFiles(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files fstest.MapFS
+	}{
+		{"missing down", fstest.MapFS{
+			"m/0001_a.up.sql": {Data: []byte("CREATE TABLE a ()")},
+		}},
+		{"missing version", fstest.MapFS{
+			"m/0001_a.up.sql":   {Data: []byte("CREATE TABLE a ()")},
+			"m/0001_a.down.sql": {Data: []byte("DROP TABLE a")},
+			"m/0003_c.up.sql":   {Data: []byte("CREATE TABLE c ()")},
+			"m/0003_c.down.sql": {Data: []byte("DROP TABLE c")},
+		}},
+		{"invalid name", fstest.MapFS{
+			"m/first.up.sql": {Data: []byte("CREATE TABLE a ()")},
+		}},
+		{"invalid suffix", fstest.MapFS{
+			"m/0001_a.sql": {Data: []byte("CREATE TABLE a ()")},
+		}},
+	}
+
+	for _, tt := range testCases {
+		_, err := loadMigrations(tt.files, "m")
+		require.Error(t, err, tt.name)
+	}
+}
+
+func TestMigratorUpAppliesPendingMigrations(t *testing.T) {
+	migrator, mock := setupMockMigrator()
+
+	expectSchemaVersionTable(mock)
+
+	mock.ExpectBegin()
+	expectMigrationLock(mock, 1)
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	expectMigrationLock(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE b ()`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_version (version, name) VALUES ($1, $2)`)).
+		WithArgs(2, "b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_version`)).
+		WillReturnRows(mock.NewRows([]string{"version"}).AddRow(2))
+
+	version, err := migrator.Up(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2), version)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorDownRevertsLatestMigration(t *testing.T) {
+	migrator, mock := setupMockMigrator()
+
+	expectSchemaVersionTable(mock)
+
+	mock.ExpectBegin()
+	expectMigrationLock(mock, 2)
+	mock.ExpectExec(regexp.QuoteMeta(`DROP TABLE b`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_version WHERE version = $1`)).
+		WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_version`)).
+		WillReturnRows(mock.NewRows([]string{"version"}).AddRow(1))
+
+	version, err := migrator.Down(context.Background(), 1)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(1), version)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorCheckVersion(t *testing.T) {
+	testCases := []struct {
+		current     int64
+		expectedErr error
+	}{
+		{2, nil},
+		{1, &SchemaVersionError{Current: 1, Expected: 2}},
+		{0, &SchemaVersionError{Current: 0, Expected: 2}},
+	}
+
+	for _, tt := range testCases {
+		migrator, mock := setupMockMigrator()
+
+		expectSchemaVersionTableExists(mock, true)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_version`)).
+			WillReturnRows(mock.NewRows([]string{"version"}).AddRow(tt.current))
+
+		err := migrator.CheckVersion(context.Background())
+
+		require.Equal(t, tt.expectedErr, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestMigratorCheckVersionWithoutSchemaVersionTable(t *testing.T) {
+	migrator, mock := setupMockMigrator()
+
+	expectSchemaVersionTableExists(mock, false)
+
+	err := migrator.CheckVersion(context.Background())
+
+	require.Equal(t, &SchemaVersionError{Current: 0, Expected: 2}, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigratorForceRejectsUnknownVersion(t *testing.T) {
+	migrator, mock := setupMockMigrator()
+
+	err := migrator.Force(context.Background(), 9)
+
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func setupMockMigrator() (*Migrator, sqlmock.Sqlmock) {
+	db, mock := setupMockDB()
+	return &Migrator{db: db, dialect: DialectPostgres, migrations: []Migration{
+		{Version: 1, Name: "a", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "b", Up: "CREATE TABLE b ()", Down: "DROP TABLE b"},
+	}}, mock
+}
+
+func expectSchemaVersionTable(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_version`)).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectMigrationLock(mock sqlmock.Sqlmock, current int64) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).
+		WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_version`)).
+		WillReturnRows(mock.NewRows([]string{"version"}).AddRow(current))
+}
+
+func expectSchemaVersionTableExists(mock sqlmock.Sqlmock, exists bool) {
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass('schema_version') IS NOT NULL`)).
+		WillReturnRows(mock.NewRows([]string{"exists"}).AddRow(exists))
+}
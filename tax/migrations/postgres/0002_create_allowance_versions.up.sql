@@ -0,0 +1,28 @@
+CREATE TABLE "allowance_versions" (
+    "id" bigserial PRIMARY KEY,
+    "donation" bigint NOT NULL,
+    "personal" bigint NOT NULL,
+    "k-receipt" bigint NOT NULL,
+    "changed_by" text NOT NULL,
+    "changed_at" timestamptz NOT NULL DEFAULT now(),
+    "reason" text NOT NULL DEFAULT '',
+    "previous_version" bigint REFERENCES "allowance_versions" ("id"),
+    "rollback_of" bigint REFERENCES "allowance_versions" ("id")
+);
+
+COMMENT ON TABLE "allowance_versions" IS 'immutable history of allowance settings, rows are never updated or deleted';
+
+INSERT INTO "allowance_versions" (
+    "donation", "personal", "k-receipt", "changed_by", "reason"
+)
+SELECT "donation", "personal", "k-receipt", 'system', 'initial settings'
+FROM "allowances"
+WHERE "id" = 1;
+
+ALTER TABLE "allowances" ADD COLUMN "version" bigint;
+
+UPDATE "allowances" SET "version" = (SELECT max("id") FROM "allowance_versions");
+
+ALTER TABLE "allowances" ALTER COLUMN "version" SET NOT NULL;
+
+COMMENT ON COLUMN "allowances"."version" IS 'id of the allowance_versions row currently in effect';
@@ -0,0 +1 @@
+DROP TABLE "deduction_proposals";
@@ -0,0 +1,18 @@
+CREATE TABLE "deduction_proposals" (
+    "id" bigserial PRIMARY KEY,
+    "allowance_type" text NOT NULL,
+    "amount" bigint NOT NULL,
+    "reason" text NOT NULL DEFAULT '',
+    "base_version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "status" text NOT NULL DEFAULT 'pending',
+    "proposed_by" text NOT NULL,
+    "proposed_at" timestamptz NOT NULL DEFAULT now(),
+    "decided_by" text,
+    "decided_at" timestamptz,
+    "decision_reason" text NOT NULL DEFAULT '',
+    "applied_version" bigint REFERENCES "allowance_versions" ("id")
+);
+
+CREATE INDEX ON "deduction_proposals" ("status");
+
+COMMENT ON TABLE "deduction_proposals" IS 'personal and k-receipt changes waiting for approval by a second admin';
@@ -0,0 +1,22 @@
+CREATE TABLE "admin_audit_log" (
+    "id" bigserial PRIMARY KEY,
+    "occurred_at" timestamptz NOT NULL DEFAULT now(),
+    "username" text NOT NULL,
+    "authenticated" boolean NOT NULL,
+    "source_ip" text NOT NULL,
+    "request_id" text NOT NULL,
+    "method" text NOT NULL,
+    "endpoint" text NOT NULL,
+    "payload" text NOT NULL,
+    "status" int NOT NULL
+);
+
+CREATE INDEX ON "admin_audit_log" ("occurred_at");
+
+CREATE INDEX ON "admin_audit_log" ("username");
+
+CREATE RULE "admin_audit_log_no_update" AS ON UPDATE TO "admin_audit_log" DO INSTEAD NOTHING;
+
+CREATE RULE "admin_audit_log_no_delete" AS ON DELETE TO "admin_audit_log" DO INSTEAD NOTHING;
+
+COMMENT ON TABLE "admin_audit_log" IS 'append-only record of every admin request, including failed authentication';
@@ -0,0 +1,37 @@
+ALTER TABLE "allowance_versions" ADD COLUMN "personal" bigint, ADD COLUMN "donation" bigint, ADD COLUMN "k-receipt" bigint;
+
+UPDATE "allowance_versions" SET
+    "personal" = (SELECT "amount" FROM "allowance_caps" WHERE "version_id" = "allowance_versions"."id" AND "deduction_type" = 'personal'),
+    "donation" = (SELECT "amount" FROM "allowance_caps" WHERE "version_id" = "allowance_versions"."id" AND "deduction_type" = 'donation'),
+    "k-receipt" = (SELECT "amount" FROM "allowance_caps" WHERE "version_id" = "allowance_versions"."id" AND "deduction_type" = 'k-receipt');
+
+ALTER TABLE "allowance_versions"
+    ALTER COLUMN "personal" SET NOT NULL,
+    ALTER COLUMN "donation" SET NOT NULL,
+    ALTER COLUMN "k-receipt" SET NOT NULL;
+
+CREATE TABLE "allowances" (
+    "id" bigserial PRIMARY KEY,
+    "donation" bigint NOT NULL,
+    "personal" bigint NOT NULL,
+    "k-receipt" bigint NOT NULL,
+    "version" bigint NOT NULL
+);
+
+CREATE INDEX ON "allowances" ("donation", "personal", "k-receipt");
+
+INSERT INTO "allowances" ("id", "donation", "personal", "k-receipt", "version")
+SELECT 1, "v"."donation", "v"."personal", "v"."k-receipt", "v"."id"
+FROM "tax_years" "y"
+JOIN "allowance_versions" "v" ON "v"."id" = "y"."version"
+WHERE "y"."is_current";
+
+SELECT setval(pg_get_serial_sequence('allowances', 'id'), 1);
+
+ALTER TABLE "deduction_proposals" DROP CONSTRAINT "deduction_proposals_allowance_type_fkey";
+
+DROP TABLE "tax_years";
+
+DROP TABLE "allowance_caps";
+
+DROP TABLE "deduction_types";
@@ -0,0 +1,56 @@
+CREATE TABLE "deduction_types" (
+    "code" text PRIMARY KEY,
+    "description" text NOT NULL,
+    "min_amount" bigint NOT NULL,
+    "max_amount" bigint NOT NULL
+);
+
+COMMENT ON TABLE "deduction_types" IS 'allowance kinds, adding a kind is an INSERT instead of ALTER TABLE';
+
+INSERT INTO "deduction_types" ("code", "description", "min_amount", "max_amount") VALUES
+    ('personal', 'ค่าลดหย่อนส่วนตัว', 10000, 100000),
+    ('donation', 'เงินบริจาค', 0, 100000),
+    ('k-receipt', 'โครงการช้อปลดภาษี', 0, 100000);
+
+CREATE TABLE "allowance_caps" (
+    "version_id" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "deduction_type" text NOT NULL REFERENCES "deduction_types" ("code"),
+    "amount" bigint NOT NULL,
+    PRIMARY KEY ("version_id", "deduction_type")
+);
+
+COMMENT ON TABLE "allowance_caps" IS 'amount of each deduction type in a settings version, rows are never updated or deleted';
+
+-- the active version takes its amounts from the allowances row so the
+-- settings in effect are carried over exactly
+INSERT INTO "allowance_caps" ("version_id", "deduction_type", "amount")
+SELECT "v"."id", "t"."code",
+    CASE "t"."code"
+        WHEN 'personal' THEN COALESCE("a"."personal", "v"."personal")
+        WHEN 'donation' THEN COALESCE("a"."donation", "v"."donation")
+        WHEN 'k-receipt' THEN COALESCE("a"."k-receipt", "v"."k-receipt")
+    END
+FROM "allowance_versions" "v"
+CROSS JOIN "deduction_types" "t"
+LEFT JOIN "allowances" "a" ON "a"."id" = 1 AND "a"."version" = "v"."id";
+
+CREATE TABLE "tax_years" (
+    "year" int PRIMARY KEY,
+    "version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "is_current" boolean NOT NULL DEFAULT false
+);
+
+CREATE UNIQUE INDEX ON "tax_years" ("is_current") WHERE "is_current";
+
+COMMENT ON COLUMN "tax_years"."version" IS 'id of the allowance_versions row in effect for the year';
+
+INSERT INTO "tax_years" ("year", "version", "is_current")
+SELECT extract(year FROM now())::int, "version", true
+FROM "allowances"
+WHERE "id" = 1;
+
+ALTER TABLE "deduction_proposals" ADD FOREIGN KEY ("allowance_type") REFERENCES "deduction_types" ("code");
+
+ALTER TABLE "allowance_versions" DROP COLUMN "personal", DROP COLUMN "donation", DROP COLUMN "k-receipt";
+
+DROP TABLE "allowances";
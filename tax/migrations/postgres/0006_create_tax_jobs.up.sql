@@ -0,0 +1,19 @@
+CREATE TABLE "tax_jobs" (
+    "id" text PRIMARY KEY,
+    "status" text NOT NULL DEFAULT 'queued',
+    "filename" text NOT NULL,
+    "mode" text NOT NULL,
+    "sheet" text NOT NULL DEFAULT '',
+    "input" bytea NOT NULL,
+    "result" bytea,
+    "total_rows" int NOT NULL DEFAULT 0,
+    "processed_rows" int NOT NULL DEFAULT 0,
+    "invalid_rows" int NOT NULL DEFAULT 0,
+    "error" text NOT NULL DEFAULT '',
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    "started_at" timestamptz,
+    "finished_at" timestamptz,
+    "updated_at" timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX "tax_jobs_status_idx" ON "tax_jobs" ("status");
@@ -0,0 +1 @@
+ALTER TABLE "tax_jobs" ADD COLUMN "detail" boolean NOT NULL DEFAULT false;
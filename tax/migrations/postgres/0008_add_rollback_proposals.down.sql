@@ -0,0 +1,7 @@
+DELETE FROM "deduction_proposals" WHERE "rollback_of" IS NOT NULL;
+
+ALTER TABLE "deduction_proposals" DROP CONSTRAINT "deduction_proposals_change_check";
+
+ALTER TABLE "deduction_proposals" DROP COLUMN "rollback_of";
+
+ALTER TABLE "deduction_proposals" ALTER COLUMN "allowance_type" SET NOT NULL;
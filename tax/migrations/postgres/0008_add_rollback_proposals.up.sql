@@ -0,0 +1,7 @@
+ALTER TABLE "deduction_proposals" ALTER COLUMN "allowance_type" DROP NOT NULL;
+
+ALTER TABLE "deduction_proposals" ADD COLUMN "rollback_of" bigint REFERENCES "allowance_versions" ("id");
+
+ALTER TABLE "deduction_proposals" ADD CONSTRAINT "deduction_proposals_change_check" CHECK (("allowance_type" IS NULL) <> ("rollback_of" IS NULL));
+
+COMMENT ON COLUMN "deduction_proposals"."rollback_of" IS 'version to roll back to, set instead of allowance_type for a rollback waiting for approval';
@@ -0,0 +1,14 @@
+CREATE TABLE "allowances" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "donation" bigint NOT NULL,
+    "personal" bigint NOT NULL,
+    "k-receipt" bigint NOT NULL
+);
+
+CREATE INDEX "allowances_donation_personal_k-receipt_idx" ON "allowances" ("donation", "personal", "k-receipt");
+
+INSERT INTO "allowances" (
+    "donation", "personal", "k-receipt"
+) VALUES (
+    100000, 60000, 50000
+);
@@ -0,0 +1,3 @@
+ALTER TABLE "allowances" DROP COLUMN "version";
+
+DROP TABLE "allowance_versions";
@@ -0,0 +1,22 @@
+CREATE TABLE "allowance_versions" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "donation" bigint NOT NULL,
+    "personal" bigint NOT NULL,
+    "k-receipt" bigint NOT NULL,
+    "changed_by" text NOT NULL,
+    "changed_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "reason" text NOT NULL DEFAULT '',
+    "previous_version" bigint REFERENCES "allowance_versions" ("id"),
+    "rollback_of" bigint REFERENCES "allowance_versions" ("id")
+);
+
+INSERT INTO "allowance_versions" (
+    "donation", "personal", "k-receipt", "changed_by", "reason"
+)
+SELECT "donation", "personal", "k-receipt", 'system', 'initial settings'
+FROM "allowances"
+WHERE "id" = 1;
+
+ALTER TABLE "allowances" ADD COLUMN "version" bigint NOT NULL DEFAULT 0;
+
+UPDATE "allowances" SET "version" = (SELECT max("id") FROM "allowance_versions");
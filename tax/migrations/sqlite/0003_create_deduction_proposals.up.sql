@@ -0,0 +1,16 @@
+CREATE TABLE "deduction_proposals" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "allowance_type" text NOT NULL,
+    "amount" bigint NOT NULL,
+    "reason" text NOT NULL DEFAULT '',
+    "base_version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "status" text NOT NULL DEFAULT 'pending',
+    "proposed_by" text NOT NULL,
+    "proposed_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "decided_by" text,
+    "decided_at" TIMESTAMP,
+    "decision_reason" text NOT NULL DEFAULT '',
+    "applied_version" bigint REFERENCES "allowance_versions" ("id")
+);
+
+CREATE INDEX "deduction_proposals_status_idx" ON "deduction_proposals" ("status");
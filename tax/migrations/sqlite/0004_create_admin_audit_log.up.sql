@@ -0,0 +1,26 @@
+CREATE TABLE "admin_audit_log" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "occurred_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "username" text NOT NULL,
+    "authenticated" boolean NOT NULL,
+    "source_ip" text NOT NULL,
+    "request_id" text NOT NULL,
+    "method" text NOT NULL,
+    "endpoint" text NOT NULL,
+    "payload" text NOT NULL,
+    "status" int NOT NULL
+);
+
+CREATE INDEX "admin_audit_log_occurred_at_idx" ON "admin_audit_log" ("occurred_at");
+
+CREATE INDEX "admin_audit_log_username_idx" ON "admin_audit_log" ("username");
+
+CREATE TRIGGER "admin_audit_log_no_update" BEFORE UPDATE ON "admin_audit_log"
+BEGIN
+    SELECT RAISE(IGNORE);
+END;
+
+CREATE TRIGGER "admin_audit_log_no_delete" BEFORE DELETE ON "admin_audit_log"
+BEGIN
+    SELECT RAISE(IGNORE);
+END;
@@ -0,0 +1,55 @@
+ALTER TABLE "allowance_versions" ADD COLUMN "personal" bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE "allowance_versions" ADD COLUMN "donation" bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE "allowance_versions" ADD COLUMN "k-receipt" bigint NOT NULL DEFAULT 0;
+
+UPDATE "allowance_versions" SET
+    "personal" = (SELECT "amount" FROM "allowance_caps" WHERE "version_id" = "allowance_versions"."id" AND "deduction_type" = 'personal'),
+    "donation" = (SELECT "amount" FROM "allowance_caps" WHERE "version_id" = "allowance_versions"."id" AND "deduction_type" = 'donation'),
+    "k-receipt" = (SELECT "amount" FROM "allowance_caps" WHERE "version_id" = "allowance_versions"."id" AND "deduction_type" = 'k-receipt');
+
+CREATE TABLE "allowances" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "donation" bigint NOT NULL,
+    "personal" bigint NOT NULL,
+    "k-receipt" bigint NOT NULL,
+    "version" bigint NOT NULL DEFAULT 0
+);
+
+CREATE INDEX "allowances_donation_personal_k-receipt_idx" ON "allowances" ("donation", "personal", "k-receipt");
+
+INSERT INTO "allowances" ("id", "donation", "personal", "k-receipt", "version")
+SELECT 1, "v"."donation", "v"."personal", "v"."k-receipt", "v"."id"
+FROM "tax_years" "y"
+JOIN "allowance_versions" "v" ON "v"."id" = "y"."version"
+WHERE "y"."is_current";
+
+CREATE TABLE "deduction_proposals_old" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "allowance_type" text NOT NULL,
+    "amount" bigint NOT NULL,
+    "reason" text NOT NULL DEFAULT '',
+    "base_version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "status" text NOT NULL DEFAULT 'pending',
+    "proposed_by" text NOT NULL,
+    "proposed_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "decided_by" text,
+    "decided_at" TIMESTAMP,
+    "decision_reason" text NOT NULL DEFAULT '',
+    "applied_version" bigint REFERENCES "allowance_versions" ("id")
+);
+
+INSERT INTO "deduction_proposals_old" SELECT * FROM "deduction_proposals";
+
+DROP TABLE "deduction_proposals";
+
+ALTER TABLE "deduction_proposals_old" RENAME TO "deduction_proposals";
+
+CREATE INDEX "deduction_proposals_status_idx" ON "deduction_proposals" ("status");
+
+DROP TABLE "tax_years";
+
+DROP TABLE "allowance_caps";
+
+DROP TABLE "deduction_types";
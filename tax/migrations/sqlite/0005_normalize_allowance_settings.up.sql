@@ -0,0 +1,74 @@
+CREATE TABLE "deduction_types" (
+    "code" text PRIMARY KEY,
+    "description" text NOT NULL,
+    "min_amount" bigint NOT NULL,
+    "max_amount" bigint NOT NULL
+);
+
+INSERT INTO "deduction_types" ("code", "description", "min_amount", "max_amount") VALUES
+    ('personal', 'ค่าลดหย่อนส่วนตัว', 10000, 100000),
+    ('donation', 'เงินบริจาค', 0, 100000),
+    ('k-receipt', 'โครงการช้อปลดภาษี', 0, 100000);
+
+CREATE TABLE "allowance_caps" (
+    "version_id" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "deduction_type" text NOT NULL REFERENCES "deduction_types" ("code"),
+    "amount" bigint NOT NULL,
+    PRIMARY KEY ("version_id", "deduction_type")
+);
+
+INSERT INTO "allowance_caps" ("version_id", "deduction_type", "amount")
+SELECT "v"."id", "t"."code",
+    CASE "t"."code"
+        WHEN 'personal' THEN COALESCE("a"."personal", "v"."personal")
+        WHEN 'donation' THEN COALESCE("a"."donation", "v"."donation")
+        WHEN 'k-receipt' THEN COALESCE("a"."k-receipt", "v"."k-receipt")
+    END
+FROM "allowance_versions" "v"
+CROSS JOIN "deduction_types" "t"
+LEFT JOIN "allowances" "a" ON "a"."id" = 1 AND "a"."version" = "v"."id";
+
+CREATE TABLE "tax_years" (
+    "year" int PRIMARY KEY,
+    "version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "is_current" boolean NOT NULL DEFAULT false
+);
+
+CREATE UNIQUE INDEX "tax_years_is_current_idx" ON "tax_years" ("is_current") WHERE "is_current";
+
+INSERT INTO "tax_years" ("year", "version", "is_current")
+SELECT CAST(strftime('%Y', 'now') AS int), "version", true
+FROM "allowances"
+WHERE "id" = 1;
+
+-- SQLite cannot add a foreign key to an existing table, so the table is rebuilt
+CREATE TABLE "deduction_proposals_new" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "allowance_type" text NOT NULL REFERENCES "deduction_types" ("code"),
+    "amount" bigint NOT NULL,
+    "reason" text NOT NULL DEFAULT '',
+    "base_version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "status" text NOT NULL DEFAULT 'pending',
+    "proposed_by" text NOT NULL,
+    "proposed_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "decided_by" text,
+    "decided_at" TIMESTAMP,
+    "decision_reason" text NOT NULL DEFAULT '',
+    "applied_version" bigint REFERENCES "allowance_versions" ("id")
+);
+
+INSERT INTO "deduction_proposals_new" SELECT * FROM "deduction_proposals";
+
+DROP TABLE "deduction_proposals";
+
+ALTER TABLE "deduction_proposals_new" RENAME TO "deduction_proposals";
+
+CREATE INDEX "deduction_proposals_status_idx" ON "deduction_proposals" ("status");
+
+ALTER TABLE "allowance_versions" DROP COLUMN "personal";
+
+ALTER TABLE "allowance_versions" DROP COLUMN "donation";
+
+ALTER TABLE "allowance_versions" DROP COLUMN "k-receipt";
+
+DROP TABLE "allowances";
@@ -0,0 +1,25 @@
+CREATE TABLE "deduction_proposals_old" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "allowance_type" text NOT NULL REFERENCES "deduction_types" ("code"),
+    "amount" bigint NOT NULL,
+    "reason" text NOT NULL DEFAULT '',
+    "base_version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "status" text NOT NULL DEFAULT 'pending',
+    "proposed_by" text NOT NULL,
+    "proposed_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "decided_by" text,
+    "decided_at" TIMESTAMP,
+    "decision_reason" text NOT NULL DEFAULT '',
+    "applied_version" bigint REFERENCES "allowance_versions" ("id")
+);
+
+INSERT INTO "deduction_proposals_old"
+SELECT "id", "allowance_type", "amount", "reason", "base_version", "status", "proposed_by", "proposed_at", "decided_by", "decided_at", "decision_reason", "applied_version"
+FROM "deduction_proposals"
+WHERE "rollback_of" IS NULL;
+
+DROP TABLE "deduction_proposals";
+
+ALTER TABLE "deduction_proposals_old" RENAME TO "deduction_proposals";
+
+CREATE INDEX "deduction_proposals_status_idx" ON "deduction_proposals" ("status");
@@ -0,0 +1,27 @@
+-- SQLite cannot drop NOT NULL or add a check to an existing table, so the table is rebuilt
+CREATE TABLE "deduction_proposals_new" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "allowance_type" text REFERENCES "deduction_types" ("code"),
+    "amount" bigint NOT NULL,
+    "reason" text NOT NULL DEFAULT '',
+    "base_version" bigint NOT NULL REFERENCES "allowance_versions" ("id"),
+    "status" text NOT NULL DEFAULT 'pending',
+    "proposed_by" text NOT NULL,
+    "proposed_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "decided_by" text,
+    "decided_at" TIMESTAMP,
+    "decision_reason" text NOT NULL DEFAULT '',
+    "applied_version" bigint REFERENCES "allowance_versions" ("id"),
+    "rollback_of" bigint REFERENCES "allowance_versions" ("id"),
+    CHECK (("allowance_type" IS NULL) <> ("rollback_of" IS NULL))
+);
+
+INSERT INTO "deduction_proposals_new" ("id", "allowance_type", "amount", "reason", "base_version", "status", "proposed_by", "proposed_at", "decided_by", "decided_at", "decision_reason", "applied_version")
+SELECT "id", "allowance_type", "amount", "reason", "base_version", "status", "proposed_by", "proposed_at", "decided_by", "decided_at", "decision_reason", "applied_version"
+FROM "deduction_proposals";
+
+DROP TABLE "deduction_proposals";
+
+ALTER TABLE "deduction_proposals_new" RENAME TO "deduction_proposals";
+
+CREATE INDEX "deduction_proposals_status_idx" ON "deduction_proposals" ("status");
@@ -0,0 +1,84 @@
+package tax
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// taxRowChunkSize is how many rows of an upload are read ahead and calculated
+// together before their results are handed on in file order.
+const taxRowChunkSize = 256
+
+// rowPool bounds how many rows are calculated at the same time across all
+// uploads. perUpload caps a single upload, so a large file leaves room for
+// other uploads and for interactive calculations.
+type rowPool struct {
+	slots     chan struct{}
+	perUpload int
+}
+
+func newRowPool(workers, perUpload int) *rowPool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if perUpload <= 0 || perUpload > workers {
+		perUpload = max(1, workers/2)
+	}
+
+	return &rowPool{slots: make(chan struct{}, workers), perUpload: perUpload}
+}
+
+// UseRowWorkers sets how many rows of uploads are calculated concurrently in
+// total and per upload, a value of 0 keeps the default.
+func (h *Handler) UseRowWorkers(workers, perUpload int) {
+	h.rows = newRowPool(workers, perUpload)
+}
+
+// run calls fn for every index below n. The first worker waits for a free
+// slot, further workers only join while the pool has slots to spare. A nil
+// pool runs fn in the calling goroutine.
+func (p *rowPool) run(ctx context.Context, n int, fn func(i int)) error {
+	if p == nil {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return nil
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+
+	work := func() {
+		defer wg.Done()
+		defer func() { <-p.slots }()
+
+		for i := int(next.Add(1)) - 1; i < n; i = int(next.Add(1)) - 1 {
+			fn(i)
+		}
+	}
+
+	wg.Add(1)
+	go work()
+
+spawn:
+	for workers := 1; workers < min(p.perUpload, n); workers++ {
+		select {
+		case p.slots <- struct{}{}:
+			wg.Add(1)
+			go work()
+		default:
+			break spawn
+		}
+	}
+
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,83 @@
+package tax
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRowPoolDefaults(t *testing.T) {
+	pool := newRowPool(8, 0)
+	require.Equal(t, 8, cap(pool.slots))
+	require.Equal(t, 4, pool.perUpload)
+
+	pool = newRowPool(1, 0)
+	require.Equal(t, 1, pool.perUpload)
+
+	pool = newRowPool(2, 5)
+	require.Equal(t, 1, pool.perUpload)
+}
+
+func TestRowPoolCapsWorkersPerUpload(t *testing.T) {
+	pool := newRowPool(8, 3)
+
+	var running, peak atomic.Int64
+	var mu sync.Mutex
+	done := make([]bool, 100)
+
+	err := pool.run(context.Background(), len(done), func(i int) {
+		current := running.Add(1)
+		defer running.Add(-1)
+
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		done[i] = true
+		mu.Unlock()
+	})
+
+	require.NoError(t, err)
+	require.LessOrEqual(t, peak.Load(), int64(3))
+	require.NotContains(t, done, false)
+	require.Empty(t, pool.slots)
+}
+
+func TestRowPoolWaitsForFreeSlot(t *testing.T) {
+	pool := newRowPool(1, 1)
+	pool.slots <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := pool.run(ctx, 1, func(int) { t.Fatal("row calculated without a free slot") })
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCalculateTaxWithCSVKeepsRowOrderWithWorkers(t *testing.T) {
+	content := syntheticTaxesCSV(2000)
+
+	sequential := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+	sequential.rows = nil
+	expected, c := mockNewRequestCSV(t, content)
+	require.NoError(t, sequential.CalculateTaxWithCSV(c))
+
+	concurrent := NewHandler(NewMemoryStore(DefaultAllowanceSettings), nil)
+	concurrent.UseRowWorkers(4, 4)
+	got, c := mockNewRequestCSV(t, content)
+	require.NoError(t, concurrent.CalculateTaxWithCSV(c))
+
+	require.Equal(t, http.StatusOK, got.Code)
+	require.Equal(t, expected.Body.String(), got.Body.String())
+}
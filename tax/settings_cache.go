@@ -0,0 +1,87 @@
+package tax
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const DefaultSettingsCacheMaxStaleness = time.Minute
+
+type SettingsCache struct {
+	SettingsStore
+	maxStaleness time.Duration
+
+	mu       sync.RWMutex
+	snapshot SettingsSnapshot
+	loadedAt time.Time
+	valid    bool
+	// generation counts invalidations, so a load that was already running
+	// when the settings changed is not kept as fresh
+	generation uint64
+}
+
+func NewSettingsCache(store SettingsStore, maxStaleness time.Duration) *SettingsCache {
+	return &SettingsCache{SettingsStore: store, maxStaleness: maxStaleness}
+}
+
+func (c *SettingsCache) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	c.mu.RLock()
+	snapshot, fresh := c.snapshot, c.valid && time.Since(c.loadedAt) < c.maxStaleness
+	c.mu.RUnlock()
+
+	if fresh {
+		return snapshot, nil
+	}
+
+	return c.Refresh(ctx)
+}
+
+func (c *SettingsCache) Refresh(ctx context.Context) (SettingsSnapshot, error) {
+	c.mu.RLock()
+	generation := c.generation
+	c.mu.RUnlock()
+
+	snapshot, err := c.SettingsStore.LoadSettings(ctx)
+	if err != nil {
+		return SettingsSnapshot{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// a slower concurrent refresh must not replace a newer version
+	if c.valid && snapshot.Version < c.snapshot.Version {
+		return c.snapshot, nil
+	}
+
+	// invalidated while loading, the snapshot may predate the change
+	if generation != c.generation {
+		return snapshot, nil
+	}
+
+	c.snapshot = snapshot
+	c.loadedAt = time.Now()
+	c.valid = true
+
+	return snapshot, nil
+}
+
+func (c *SettingsCache) Invalidate() {
+	c.mu.Lock()
+	c.valid = false
+	c.generation++
+	c.mu.Unlock()
+}
+
+func (c *SettingsCache) settingsChanged(version int64) {
+	c.Invalidate()
+}
+
+func (c *SettingsCache) ApproveProposal(ctx context.Context, id int64, approvedBy, reason string) (DeductionProposal, AllowanceVersion, error) {
+	proposal, version, err := c.SettingsStore.ApproveProposal(ctx, id, approvedBy, reason)
+	if err == nil {
+		c.Invalidate()
+	}
+	return proposal, version, err
+}
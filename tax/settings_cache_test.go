@@ -0,0 +1,125 @@
+package tax
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettingsCacheServesCachedSnapshot(t *testing.T) {
+	store := &countingSettingsStore{SettingsStore: NewMemoryStore(DefaultAllowanceSettings)}
+	cache := NewSettingsCache(store, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		settings, err := cache.LoadSettings(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(1), settings.Version)
+	}
+
+	require.Equal(t, 1, store.loads)
+}
+
+func TestSettingsCacheReloadsAfterInvalidate(t *testing.T) {
+	store := &countingSettingsStore{SettingsStore: NewMemoryStore(DefaultAllowanceSettings)}
+	cache := NewSettingsCache(store, time.Hour)
+
+	_, err := cache.LoadSettings(context.Background())
+	require.NoError(t, err)
+
+	cache.Invalidate()
+
+	_, err = cache.LoadSettings(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, store.loads)
+}
+
+func TestSettingsCacheReloadsAfterMaxStaleness(t *testing.T) {
+	store := &countingSettingsStore{SettingsStore: NewMemoryStore(DefaultAllowanceSettings)}
+	cache := NewSettingsCache(store, time.Millisecond)
+
+	_, err := cache.LoadSettings(context.Background())
+	require.NoError(t, err)
+
+	time.Sleep(2 * time.Millisecond)
+
+	_, err = cache.LoadSettings(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, store.loads)
+}
+
+func TestSettingsCacheInvalidatesOnApprove(t *testing.T) {
+	ctx := context.Background()
+	cache := NewSettingsCache(NewMemoryStore(DefaultAllowanceSettings), time.Hour)
+
+	_, err := cache.LoadSettings(ctx)
+	require.NoError(t, err)
+
+	proposal, err := cache.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "alice"})
+	require.NoError(t, err)
+	_, _, err = cache.ApproveProposal(ctx, proposal.ID, "bob", "")
+	require.NoError(t, err)
+
+	settings, err := cache.LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), settings.Version)
+	require.Equal(t, 70000.0, settings.Allowances.Personal)
+}
+
+func TestSettingsCacheKeepsNewerSnapshot(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	cache := NewSettingsCache(store, time.Hour)
+
+	proposal, _ := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "alice"})
+	_, _, err := cache.ApproveProposal(ctx, proposal.ID, "bob", "")
+	require.NoError(t, err)
+
+	_, err = cache.Refresh(ctx)
+	require.NoError(t, err)
+
+	cache.SettingsStore = NewMemoryStore(DefaultAllowanceSettings)
+	settings, err := cache.Refresh(ctx)
+
+	require.NoError(t, err)
+	require.Equal(t, int64(2), settings.Version)
+}
+
+func TestSettingsCacheDoesNotKeepSnapshotLoadedBeforeInvalidate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	slowStore := &changingSettingsStore{SettingsStore: store}
+	cache := NewSettingsCache(slowStore, time.Hour)
+
+	// the change commits and invalidates the cache after the load has read version 1
+	slowStore.afterLoad = func() {
+		proposal, _ := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "alice"})
+		_, _, err := store.ApproveProposal(ctx, proposal.ID, "bob", "")
+		require.NoError(t, err)
+		cache.settingsChanged(2)
+	}
+
+	settings, err := cache.LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), settings.Version)
+
+	settings, err = cache.LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), settings.Version)
+	require.Equal(t, 70000.0, settings.Allowances.Personal)
+}
+
+type changingSettingsStore struct {
+	SettingsStore
+	afterLoad func()
+}
+
+func (s *changingSettingsStore) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	snapshot, err := s.SettingsStore.LoadSettings(ctx)
+	if s.afterLoad != nil {
+		s.afterLoad()
+		s.afterLoad = nil
+	}
+	return snapshot, err
+}
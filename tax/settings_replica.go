@@ -0,0 +1,90 @@
+package tax
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+type SettingsLoader interface {
+	LoadSettings(ctx context.Context) (SettingsSnapshot, error)
+}
+
+// ReadReplica sends admin handlers to the primary store and calculation reads
+// to a cached read replica. Every version written through it, or announced
+// by another server, becomes the minimum a calculation may see, so a replica
+// that has not replayed the change yet is skipped in favour of the primary
+// and an admin always calculates with the settings they just changed.
+type ReadReplica struct {
+	SettingsStore
+	replica    *SettingsCache
+	minVersion atomic.Int64
+}
+
+func NewReadReplica(primary SettingsStore, replica *SettingsCache) *ReadReplica {
+	return &ReadReplica{SettingsStore: primary, replica: replica}
+}
+
+// Calculations returns the loader used by the calculation handlers.
+func (r *ReadReplica) Calculations() SettingsLoader {
+	return replicaSettingsLoader{r}
+}
+
+func (r *ReadReplica) loadCalculationSettings(ctx context.Context) (SettingsSnapshot, error) {
+	minVersion := r.minVersion.Load()
+
+	snapshot, err := r.replica.LoadSettings(ctx)
+	if err == nil && snapshot.Version < minVersion {
+		// the cached replica snapshot may predate the change, ask the replica again
+		snapshot, err = r.replica.Refresh(ctx)
+	}
+
+	if err != nil {
+		log.Printf("Read replica is unavailable, reading settings from the primary: %v", err)
+		return r.SettingsStore.LoadSettings(ctx)
+	}
+
+	if snapshot.Version < minVersion {
+		return r.SettingsStore.LoadSettings(ctx)
+	}
+
+	return snapshot, nil
+}
+
+// Refresh reloads the admin settings from the primary.
+func (r *ReadReplica) Refresh(ctx context.Context) (SettingsSnapshot, error) {
+	if primary, ok := r.SettingsStore.(settingsRefresher); ok {
+		return primary.Refresh(ctx)
+	}
+	return r.SettingsStore.LoadSettings(ctx)
+}
+
+func (r *ReadReplica) observeVersion(version int64) {
+	for {
+		current := r.minVersion.Load()
+		if version <= current || r.minVersion.CompareAndSwap(current, version) {
+			return
+		}
+	}
+}
+
+func (r *ReadReplica) settingsChanged(version int64) {
+	r.observeVersion(version)
+	r.replica.Invalidate()
+}
+
+func (r *ReadReplica) ApproveProposal(ctx context.Context, id int64, approvedBy, reason string) (DeductionProposal, AllowanceVersion, error) {
+	proposal, version, err := r.SettingsStore.ApproveProposal(ctx, id, approvedBy, reason)
+	if err == nil {
+		r.settingsChanged(version.Version)
+	}
+	return proposal, version, err
+}
+
+type replicaSettingsLoader struct {
+	replica *ReadReplica
+}
+
+func (l replicaSettingsLoader) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	return l.replica.loadCalculationSettings(ctx)
+}
@@ -0,0 +1,128 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func setupReadReplica() (*ReadReplica, *MemoryStore, *countingSettingsStore) {
+	primary := NewMemoryStore(DefaultAllowanceSettings)
+	replica := &countingSettingsStore{SettingsStore: NewMemoryStore(DefaultAllowanceSettings)}
+	return NewReadReplica(primary, NewSettingsCache(replica, time.Hour)), primary, replica
+}
+
+func approvePersonalAllowance(t *testing.T, store SettingsStore, amount float64) AllowanceVersion {
+	ctx := context.Background()
+
+	proposal, err := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: amount, ProposedBy: "alice"})
+	require.NoError(t, err)
+	_, version, err := store.ApproveProposal(ctx, proposal.ID, "bob", "")
+	require.NoError(t, err)
+
+	return version
+}
+
+func TestReadReplicaServesCalculationsFromReplica(t *testing.T) {
+	replica, _, replicaStore := setupReadReplica()
+
+	for i := 0; i < 3; i++ {
+		settings, err := replica.Calculations().LoadSettings(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(1), settings.Version)
+	}
+
+	require.Equal(t, 1, replicaStore.loads)
+}
+
+func TestReadReplicaReadsYourWritesWhileReplicaLags(t *testing.T) {
+	replica, _, replicaStore := setupReadReplica()
+	ctx := context.Background()
+
+	_, err := replica.Calculations().LoadSettings(ctx)
+	require.NoError(t, err)
+
+	version := approvePersonalAllowance(t, replica, 70000)
+
+	settings, err := replica.Calculations().LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, version.Version, settings.Version)
+	require.Equal(t, 70000.0, settings.Allowances.Personal)
+
+	// once the replica has replayed the change it serves calculations again
+	approvePersonalAllowance(t, replicaStore.SettingsStore, 70000)
+	loads := replicaStore.loads
+
+	settings, err = replica.Calculations().LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, version.Version, settings.Version)
+	require.Equal(t, loads+1, replicaStore.loads)
+}
+
+func TestReadReplicaKeepsAdminReadsOnPrimary(t *testing.T) {
+	replica, primary, _ := setupReadReplica()
+
+	approvePersonalAllowance(t, primary, 80000)
+
+	settings, err := replica.LoadSettings(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), settings.Version)
+}
+
+func TestReadReplicaRaisesMinimumVersionOnNotification(t *testing.T) {
+	replica, primary, _ := setupReadReplica()
+	ctx := context.Background()
+
+	_, err := replica.Calculations().LoadSettings(ctx)
+	require.NoError(t, err)
+
+	// another server approved the change and announced version 2
+	approvePersonalAllowance(t, primary, 80000)
+	replica.settingsChanged(2)
+
+	settings, err := replica.Calculations().LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 80000.0, settings.Allowances.Personal)
+}
+
+func TestReadReplicaFallsBackToPrimaryWhenReplicaFails(t *testing.T) {
+	primary := NewMemoryStore(DefaultAllowanceSettings)
+	replica := NewReadReplica(primary, NewSettingsCache(&failingSettingsStore{SettingsStore: primary, err: ErrStoreUnavailable}, time.Hour))
+
+	settings, err := replica.Calculations().LoadSettings(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, int64(1), settings.Version)
+}
+
+func TestCalculateTaxUsesCalculationSettings(t *testing.T) {
+	replica, _, replicaStore := setupReadReplica()
+	h := NewHandler(replica, nil)
+	h.UseCalculationSettings(replica.Calculations())
+
+	rec, c := mockNewRequest(TaxInfo{TotalIncome: 500000}, t, echo.New(), "/tax/calculations")
+
+	err := h.CalculateTax(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, replicaStore.loads)
+
+	var responseBody TaxPayable
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, int64(1), responseBody.SettingsVersion)
+}
+
+type failingSettingsStore struct {
+	SettingsStore
+	err error
+}
+
+func (s *failingSettingsStore) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	return SettingsSnapshot{}, s.err
+}
@@ -0,0 +1,113 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var DefaultAllowanceSettings = AllowanceSettings{
+	Personal: 60000,
+	Donation: 100000,
+	KReceipt: 50000,
+}
+
+// DefaultDeductionLimits are the limits of the in-memory store, the same as
+// the deduction_types rows added by the migrations.
+var DefaultDeductionLimits = map[string]DeductionLimit{
+	"personal":  {Min: 10000, Max: 100000},
+	"donation":  {Min: 0, Max: 100000},
+	"k-receipt": {Min: 0, Max: 100000},
+}
+
+// Errors a SettingsStore or JobStore returns for the handlers to answer with
+// 404, 409 or 403 instead of 500.
+var (
+	ErrVersionNotFound      = errors.New("no version found with the specified id")
+	ErrVersionAlreadyActive = errors.New("version is already active")
+	ErrProposalNotFound     = errors.New("no proposal found with the specified id")
+	ErrProposalNotPending   = errors.New("proposal has already been decided")
+	ErrSelfApproval         = errors.New("proposer cannot approve their own proposal")
+	ErrJobNotFound          = errors.New("no job found with the specified id")
+	ErrJobFinished          = errors.New("job has already finished")
+)
+
+// SettingsChangedError is returned when a change was based on a version that
+// is no longer current, the handlers answer it with 412 and Current.
+type SettingsChangedError struct {
+	Current SettingsSnapshot
+}
+
+func (e *SettingsChangedError) Error() string {
+	return "settings have changed since the given version"
+}
+
+type SettingsStore interface {
+	LoadSettings(ctx context.Context) (SettingsSnapshot, error)
+	ListVersions(ctx context.Context) ([]AllowanceVersion, error)
+	GetVersion(ctx context.Context, id int64) (AllowanceVersion, error)
+	DeductionLimit(ctx context.Context, allowanceType string) (DeductionLimit, error)
+	CreateProposal(ctx context.Context, proposal DeductionProposal) (DeductionProposal, error)
+	ListProposals(ctx context.Context, status string) ([]DeductionProposal, error)
+	ApproveProposal(ctx context.Context, id int64, approvedBy, reason string) (DeductionProposal, AllowanceVersion, error)
+	RejectProposal(ctx context.Context, id int64, rejectedBy, reason string) (DeductionProposal, error)
+}
+
+type AuditStore interface {
+	AppendAuditEntry(ctx context.Context, entry AuditEntry) error
+	ScanAuditEntries(ctx context.Context, filter AuditFilter, fn func(AuditEntry) error) error
+}
+
+// JobStore persists batch jobs with their upload and result, so queued and
+// running jobs survive a restart.
+type JobStore interface {
+	CreateJob(ctx context.Context, job BatchJob, input []byte) (BatchJob, error)
+	GetJob(ctx context.Context, id string) (BatchJob, error)
+	GetJobInput(ctx context.Context, id string) ([]byte, error)
+	GetJobResult(ctx context.Context, id string) ([]byte, error)
+	// ClaimJob moves a queued job, or a running job without progress or
+	// heartbeat since staleBefore, to running and reports whether this call won it.
+	ClaimJob(ctx context.Context, id string, staleBefore time.Time) (bool, error)
+	// UpdateJobProgress reports false once the job is no longer running.
+	UpdateJobProgress(ctx context.Context, id string, totalRows, processedRows, invalidRows int) (bool, error)
+	// TouchJob marks a running job as still in progress without changing its
+	// progress, and reports false once the job is no longer running.
+	TouchJob(ctx context.Context, id string) (bool, error)
+	FinishJob(ctx context.Context, id, status, message string, result []byte) error
+	CancelJob(ctx context.Context, id string) (BatchJob, error)
+	// ListResumableJobs returns queued jobs and running jobs without progress since staleBefore.
+	ListResumableJobs(ctx context.Context, staleBefore time.Time) ([]string, error)
+	// DeleteFinishedJobs removes jobs that finished before finishedBefore with
+	// their upload and result, and returns how many were removed.
+	DeleteFinishedJobs(ctx context.Context, finishedBefore time.Time) (int, error)
+}
+
+type Handler struct {
+	settings     SettingsStore
+	calculations SettingsLoader
+	audit        AuditStore
+	readiness    []readinessCheck
+	rows         *rowPool
+	jobLimits    jobLimits
+	jobs         *jobRunner
+}
+
+func NewHandler(settings SettingsStore, audit AuditStore) *Handler {
+	return &Handler{
+		settings:     settings,
+		calculations: settings,
+		audit:        audit,
+		rows:         newRowPool(0, 0),
+		jobLimits:    jobLimits{maxUploadBytes: DefaultJobMaxUploadBytes, retention: DefaultJobRetention},
+	}
+}
+
+// UseCalculationSettings makes the calculation handlers read settings from
+// settings instead of the admin store.
+func (h *Handler) UseCalculationSettings(settings SettingsLoader) {
+	h.calculations = settings
+}
+
+func versionMatches(expectedVersion, currentVersion int64) bool {
+	return expectedVersion == anyVersion || expectedVersion == currentVersion
+}
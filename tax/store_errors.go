@@ -0,0 +1,132 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	ErrSettingsNotFound = errors.New("no record found with the specified id")
+	ErrStoreUnavailable = errors.New("database is unavailable, please retry later")
+	ErrStoreTimeout     = errors.New("database did not respond in time, please retry later")
+)
+
+const storeRetryAfterSeconds = "5"
+
+type storeError struct {
+	kind  error
+	cause error
+}
+
+func (e *storeError) Error() string {
+	return e.kind.Error()
+}
+
+func (e *storeError) Unwrap() []error {
+	return []error{e.kind, e.cause}
+}
+
+func classifyStoreError(err error) error {
+	var typed *storeError
+	if err == nil || errors.As(err, &typed) {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return &storeError{kind: ErrSettingsNotFound, cause: err}
+	case isStoreTimeout(err):
+		return &storeError{kind: ErrStoreTimeout, cause: err}
+	case isStoreUnavailable(err):
+		return &storeError{kind: ErrStoreUnavailable, cause: err}
+	}
+
+	return err
+}
+
+// classifyQueryError also checks the query context, because drivers report a
+// query stopped by its deadline in their own words.
+func classifyQueryError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &storeError{kind: ErrStoreTimeout, cause: err}
+	}
+	return classifyStoreError(err)
+}
+
+func isStoreTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// query_canceled is raised when statement_timeout is hit
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+func isStoreUnavailable(err error) bool {
+	if errors.Is(err, sql.ErrConnDone) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	// connection exceptions, insufficient resources and server shutdown
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		code := string(pqErr.Code)
+		return strings.HasPrefix(code, "08") || strings.HasPrefix(code, "53") || strings.HasPrefix(code, "57P")
+	}
+
+	// the database file is still locked by another writer after busy_timeout
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+	}
+
+	return false
+}
+
+func storeErrorResponse(c echo.Context, err error) error {
+	err = classifyStoreError(err)
+
+	var typed *storeError
+	if errors.As(err, &typed) {
+		log.Printf("Store error for request %s: %v: %v", getRequestID(c), typed.kind, typed.cause)
+	} else {
+		log.Printf("Store error for request %s: %v", getRequestID(c), err)
+	}
+
+	switch {
+	case errors.Is(err, ErrSettingsNotFound):
+		return c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrStoreUnavailable):
+		c.Response().Header().Set(echo.HeaderRetryAfter, storeRetryAfterSeconds)
+		return c.String(http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, ErrStoreTimeout):
+		c.Response().Header().Set(echo.HeaderRetryAfter, storeRetryAfterSeconds)
+		return c.String(http.StatusGatewayTimeout, err.Error())
+	default:
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+}
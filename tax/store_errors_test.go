@@ -0,0 +1,119 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyStoreError(t *testing.T) {
+	testCases := []struct {
+		err          error
+		expectedKind error
+	}{
+		{sql.ErrNoRows, ErrSettingsNotFound},
+		{context.DeadlineExceeded, ErrStoreTimeout},
+		{&pq.Error{Code: "57014"}, ErrStoreTimeout},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, ErrStoreTimeout},
+		{sql.ErrConnDone, ErrStoreUnavailable},
+		{driver.ErrBadConn, ErrStoreUnavailable},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), ErrStoreUnavailable},
+		{&net.OpError{Op: "dial", Err: errors.New("no route to host")}, ErrStoreUnavailable},
+		{&pq.Error{Code: "08006"}, ErrStoreUnavailable},
+		{&pq.Error{Code: "57P01"}, ErrStoreUnavailable},
+	}
+
+	for _, tt := range testCases {
+		err := classifyStoreError(tt.err)
+
+		require.ErrorIs(t, err, tt.expectedKind, tt.err.Error())
+		require.ErrorIs(t, err, tt.err, tt.err.Error())
+	}
+}
+
+func TestClassifyStoreErrorKeepsOtherErrors(t *testing.T) {
+	err := &pq.Error{Code: "23505"}
+
+	require.Same(t, err, classifyStoreError(err))
+	require.NoError(t, classifyStoreError(nil))
+}
+
+func TestCalculateTaxWhenSettingsQueryTimesOut(t *testing.T) {
+	h, mock := setupMockHandler()
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectCurrentAllowanceCaps)).
+		WillReturnError(context.DeadlineExceeded)
+
+	rec, c := mockNewRequest(TaxInfo{TotalIncome: 500000}, t, echo.New(), "/tax/calculations")
+
+	err := h.CalculateTax(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	require.Equal(t, "5", rec.Header().Get("Retry-After"))
+	require.Equal(t, ErrStoreTimeout.Error(), rec.Body.String())
+}
+
+// a driver reports a query stopped by the query timeout in its own words, so
+// these go through the query context to answer 504
+func TestListAllowanceVersionsWhenQueryTimesOut(t *testing.T) {
+	h, mock := setupMockHandlerWithQueryTimeout(20 * time.Millisecond)
+
+	mock.ExpectQuery(regexp.QuoteMeta(selectAllowanceVersions)).
+		WillDelayFor(time.Second).
+		WillReturnRows(mock.NewRows(allowanceVersionColumns))
+
+	rec, c := mockNewRequestVersion(http.MethodGet, "/admin/deductions/versions", "")
+
+	err := h.ListAllowanceVersions(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	require.Equal(t, ErrStoreTimeout.Error(), rec.Body.String())
+}
+
+func TestApproveDeductionProposalWhenQueryTimesOut(t *testing.T) {
+	h, mock := setupMockHandlerWithQueryTimeout(20 * time.Millisecond)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectDeductionProposalColumns)).
+		WillDelayFor(time.Second).
+		WillReturnRows(mock.NewRows(deductionProposalColumns))
+	mock.ExpectRollback()
+
+	rec, c := mockNewRequestVersion(http.MethodPost, "/admin/deductions/proposals/1/approve", "{}")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	c.Set(AdminUsernameContextKey, "bob")
+
+	err := h.ApproveDeductionProposal(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	require.Equal(t, ErrStoreTimeout.Error(), rec.Body.String())
+}
+
+func setupMockHandlerWithQueryTimeout(timeout time.Duration) (*Handler, sqlmock.Sqlmock) {
+	db, mock := setupMockDB()
+	store := NewPostgresStore(db, timeout)
+	return NewHandler(store, store), mock
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
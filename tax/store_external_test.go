@@ -0,0 +1,70 @@
+package tax_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Booklynn/assessment-tax/tax"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// customSettingsStore is a SettingsStore written outside the package, which
+// answers with the exported errors.
+type customSettingsStore struct {
+	tax.SettingsStore
+	current tax.SettingsSnapshot
+}
+
+func (s customSettingsStore) GetVersion(ctx context.Context, id int64) (tax.AllowanceVersion, error) {
+	return tax.AllowanceVersion{}, fmt.Errorf("version %d: %w", id, tax.ErrVersionNotFound)
+}
+
+func (s customSettingsStore) ApproveProposal(ctx context.Context, id int64, approvedBy, reason string) (tax.DeductionProposal, tax.AllowanceVersion, error) {
+	proposal := tax.DeductionProposal{ID: id, AllowanceType: "personal", Amount: 70000, BaseVersion: 1}
+	return proposal, tax.AllowanceVersion{}, &tax.SettingsChangedError{Current: s.current}
+}
+
+func newCustomStoreRequest(method, path, id string) (*httptest.ResponseRecorder, echo.Context) {
+	req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	c.Set(tax.AdminUsernameContextKey, "checker")
+
+	return rec, c
+}
+
+func TestCustomSettingsStoreVersionNotFound(t *testing.T) {
+	store := customSettingsStore{SettingsStore: tax.NewMemoryStore(tax.DefaultAllowanceSettings)}
+	h := tax.NewHandler(store, nil)
+
+	rec, c := newCustomStoreRequest(http.MethodGet, "/admin/deductions/versions/9", "9")
+
+	require.NoError(t, h.GetAllowanceVersion(c))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCustomSettingsStoreSettingsChanged(t *testing.T) {
+	current := tax.SettingsSnapshot{Version: 3, Allowances: tax.AllowanceSettings{Personal: 80000, Donation: 100000, KReceipt: 50000}}
+	store := customSettingsStore{SettingsStore: tax.NewMemoryStore(tax.DefaultAllowanceSettings), current: current}
+	h := tax.NewHandler(store, nil)
+
+	rec, c := newCustomStoreRequest(http.MethodPost, "/admin/deductions/proposals/1/approve", "1")
+
+	require.NoError(t, h.ApproveDeductionProposal(c))
+	require.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	require.Equal(t, `"3"`, rec.Header().Get("ETag"))
+
+	var responseBody tax.AllowancesPersonalDeduction
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&responseBody))
+	require.Equal(t, 80000.0, responseBody.PersonalDeduction)
+}
@@ -0,0 +1,459 @@
+package tax
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MemoryStore struct {
+	mu             sync.Mutex
+	currentVersion int64
+	versions       []AllowanceVersion
+	proposals      []DeductionProposal
+	auditEntries   []AuditEntry
+	jobs           map[string]*memoryJob
+}
+
+type memoryJob struct {
+	job       BatchJob
+	input     []byte
+	result    []byte
+	updatedAt time.Time
+}
+
+func NewMemoryStore(settings AllowanceSettings) *MemoryStore {
+	store := &MemoryStore{}
+	store.appendVersion(AllowanceVersion{
+		Settings:  settings,
+		ChangedBy: "system",
+		Reason:    "initial settings",
+	})
+	return store
+}
+
+func (s *MemoryStore) LoadSettings(ctx context.Context) (SettingsSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.currentSnapshot(), nil
+}
+
+func (s *MemoryStore) DeductionLimit(ctx context.Context, allowanceType string) (DeductionLimit, error) {
+	limit, ok := DefaultDeductionLimits[allowanceType]
+	if !ok {
+		return DeductionLimit{}, ErrSettingsNotFound
+	}
+	return limit, nil
+}
+
+func (s *MemoryStore) ListVersions(ctx context.Context) ([]AllowanceVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := make([]AllowanceVersion, 0, len(s.versions))
+	for i := len(s.versions) - 1; i >= 0; i-- {
+		versions = append(versions, s.versions[i])
+	}
+
+	return versions, nil
+}
+
+func (s *MemoryStore) GetVersion(ctx context.Context, id int64) (AllowanceVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.version(id)
+}
+
+// rollback appends a version with the amounts of targetVersion, s.mu must be held.
+func (s *MemoryStore) rollback(targetVersion, expectedVersion int64, changedBy, reason string) (AllowanceVersion, error) {
+	current := s.currentSnapshot()
+	if !versionMatches(expectedVersion, current.Version) {
+		return AllowanceVersion{}, &SettingsChangedError{Current: current}
+	}
+
+	if targetVersion == current.Version {
+		return AllowanceVersion{}, ErrVersionAlreadyActive
+	}
+
+	target, err := s.version(targetVersion)
+	if err != nil {
+		return AllowanceVersion{}, err
+	}
+
+	return s.appendVersion(AllowanceVersion{
+		Settings:        target.Settings,
+		ChangedBy:       changedBy,
+		Reason:          reason,
+		PreviousVersion: &current.Version,
+		RollbackOf:      &targetVersion,
+		Changes:         diffAllowanceSettings(current.Allowances, target.Settings),
+	}), nil
+}
+
+func (s *MemoryStore) CreateProposal(ctx context.Context, proposal DeductionProposal) (DeductionProposal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposal.ID = int64(len(s.proposals) + 1)
+	proposal.Status = proposalStatusPending
+	proposal.ProposedAt = time.Now()
+	s.proposals = append(s.proposals, proposal)
+
+	return proposal, nil
+}
+
+func (s *MemoryStore) ListProposals(ctx context.Context, status string) ([]DeductionProposal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposals := []DeductionProposal{}
+	for _, proposal := range s.proposals {
+		if proposal.Status == status {
+			proposals = append(proposals, proposal)
+		}
+	}
+
+	return proposals, nil
+}
+
+func (s *MemoryStore) ApproveProposal(ctx context.Context, id int64, approvedBy, reason string) (DeductionProposal, AllowanceVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposal, err := s.pendingProposal(id)
+	if err != nil {
+		return DeductionProposal{}, AllowanceVersion{}, err
+	}
+
+	if proposal.ProposedBy == approvedBy {
+		return DeductionProposal{}, AllowanceVersion{}, ErrSelfApproval
+	}
+
+	if proposal.RollbackOf != nil {
+		version, err := s.rollback(*proposal.RollbackOf, proposal.BaseVersion, approvedBy, proposal.Reason)
+		if err != nil {
+			return *proposal, AllowanceVersion{}, err
+		}
+
+		s.decideProposal(proposal, proposalStatusApproved, approvedBy, reason, &version.Version)
+		return *proposal, version, nil
+	}
+
+	current := s.currentSnapshot()
+	if !versionMatches(proposal.BaseVersion, current.Version) {
+		return *proposal, AllowanceVersion{}, &SettingsChangedError{Current: current}
+	}
+
+	settings := current.Allowances
+	settings.set(proposal.AllowanceType, proposal.Amount)
+
+	version := s.appendVersion(AllowanceVersion{
+		Settings:        settings,
+		ChangedBy:       approvedBy,
+		Reason:          proposal.Reason,
+		PreviousVersion: &current.Version,
+		Changes:         diffAllowanceSettings(current.Allowances, settings),
+	})
+
+	s.decideProposal(proposal, proposalStatusApproved, approvedBy, reason, &version.Version)
+
+	return *proposal, version, nil
+}
+
+func (s *MemoryStore) RejectProposal(ctx context.Context, id int64, rejectedBy, reason string) (DeductionProposal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proposal, err := s.pendingProposal(id)
+	if err != nil {
+		return DeductionProposal{}, err
+	}
+
+	s.decideProposal(proposal, proposalStatusRejected, rejectedBy, reason, nil)
+
+	return *proposal, nil
+}
+
+func (s *MemoryStore) AppendAuditEntry(ctx context.Context, entry AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = int64(len(s.auditEntries) + 1)
+	entry.OccurredAt = time.Now()
+	s.auditEntries = append(s.auditEntries, entry)
+
+	return nil
+}
+
+func (s *MemoryStore) ScanAuditEntries(ctx context.Context, filter AuditFilter, fn func(AuditEntry) error) error {
+	s.mu.Lock()
+	entries := make([]AuditEntry, 0, len(s.auditEntries))
+	for i := len(s.auditEntries) - 1; i >= 0; i-- {
+		if auditEntryMatches(s.auditEntries[i], filter) {
+			entries = append(entries, s.auditEntries[i])
+		}
+	}
+	s.mu.Unlock()
+
+	if filter.Offset >= len(entries) {
+		return nil
+	}
+	entries = entries[filter.Offset:]
+
+	if filter.Limit > 0 && filter.Limit < len(entries) {
+		entries = entries[:filter.Limit]
+	}
+
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) currentSnapshot() SettingsSnapshot {
+	return SettingsSnapshot{
+		Version:    s.currentVersion,
+		Allowances: s.versions[s.currentVersion-1].Settings,
+	}
+}
+
+func (s *MemoryStore) version(id int64) (AllowanceVersion, error) {
+	if id < 1 || id > int64(len(s.versions)) {
+		return AllowanceVersion{}, ErrVersionNotFound
+	}
+
+	return s.versions[id-1], nil
+}
+
+func (s *MemoryStore) appendVersion(version AllowanceVersion) AllowanceVersion {
+	version.Version = int64(len(s.versions) + 1)
+	version.ChangedAt = time.Now()
+
+	s.versions = append(s.versions, version)
+	s.currentVersion = version.Version
+
+	return version
+}
+
+func (s *MemoryStore) pendingProposal(id int64) (*DeductionProposal, error) {
+	if id < 1 || id > int64(len(s.proposals)) {
+		return nil, ErrProposalNotFound
+	}
+
+	proposal := &s.proposals[id-1]
+	if proposal.Status != proposalStatusPending {
+		return nil, ErrProposalNotPending
+	}
+
+	return proposal, nil
+}
+
+func (s *MemoryStore) decideProposal(proposal *DeductionProposal, status, decidedBy, reason string, appliedVersion *int64) {
+	decidedAt := time.Now()
+
+	proposal.Status = status
+	proposal.DecidedBy = decidedBy
+	proposal.DecidedAt = &decidedAt
+	proposal.DecisionReason = reason
+	proposal.AppliedVersion = appliedVersion
+}
+
+func (s *MemoryStore) CreateJob(ctx context.Context, job BatchJob, input []byte) (BatchJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = map[string]*memoryJob{}
+	}
+
+	job.Status = jobStatusQueued
+	job.CreatedAt = time.Now()
+	s.jobs[job.ID] = &memoryJob{job: job, input: input, updatedAt: job.CreatedAt}
+
+	return job, nil
+}
+
+func (s *MemoryStore) GetJob(ctx context.Context, id string) (BatchJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok {
+		return BatchJob{}, ErrJobNotFound
+	}
+
+	return stored.job, nil
+}
+
+func (s *MemoryStore) GetJobInput(ctx context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return stored.input, nil
+}
+
+func (s *MemoryStore) GetJobResult(ctx context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+
+	return stored.result, nil
+}
+
+func (s *MemoryStore) ClaimJob(ctx context.Context, id string, staleBefore time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok || !(stored.job.Status == jobStatusQueued || (stored.job.Status == jobStatusRunning && stored.updatedAt.Before(staleBefore))) {
+		return false, nil
+	}
+
+	startedAt := time.Now()
+	stored.job.Status = jobStatusRunning
+	stored.job.StartedAt = &startedAt
+	stored.updatedAt = startedAt
+
+	return true, nil
+}
+
+func (s *MemoryStore) UpdateJobProgress(ctx context.Context, id string, totalRows, processedRows, invalidRows int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok || stored.job.Status != jobStatusRunning {
+		return false, nil
+	}
+
+	stored.job.TotalRows = totalRows
+	stored.job.ProcessedRows = processedRows
+	stored.job.InvalidRows = invalidRows
+	stored.updatedAt = time.Now()
+
+	return true, nil
+}
+
+func (s *MemoryStore) TouchJob(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok || stored.job.Status != jobStatusRunning {
+		return false, nil
+	}
+
+	stored.updatedAt = time.Now()
+
+	return true, nil
+}
+
+func (s *MemoryStore) FinishJob(ctx context.Context, id, status, message string, result []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok || stored.job.Status != jobStatusRunning {
+		return nil
+	}
+
+	finishedAt := time.Now()
+	stored.job.Status = status
+	stored.job.Error = message
+	stored.job.FinishedAt = &finishedAt
+	stored.result = result
+	stored.updatedAt = finishedAt
+
+	return nil
+}
+
+func (s *MemoryStore) CancelJob(ctx context.Context, id string) (BatchJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.jobs[id]
+	if !ok {
+		return BatchJob{}, ErrJobNotFound
+	}
+	if stored.job.finished() {
+		return stored.job, ErrJobFinished
+	}
+
+	finishedAt := time.Now()
+	stored.job.Status = jobStatusCancelled
+	stored.job.FinishedAt = &finishedAt
+	stored.updatedAt = finishedAt
+
+	return stored.job, nil
+}
+
+func (s *MemoryStore) ListResumableJobs(ctx context.Context, staleBefore time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []BatchJob
+	for _, stored := range s.jobs {
+		if stored.job.Status == jobStatusQueued || (stored.job.Status == jobStatusRunning && stored.updatedAt.Before(staleBefore)) {
+			jobs = append(jobs, stored.job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+
+	return ids, nil
+}
+
+func (s *MemoryStore) DeleteFinishedJobs(ctx context.Context, finishedBefore time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for id, stored := range s.jobs {
+		if stored.job.finished() && stored.job.FinishedAt.Before(finishedBefore) {
+			delete(s.jobs, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func auditEntryMatches(entry AuditEntry, filter AuditFilter) bool {
+	switch {
+	case filter.Username != "" && entry.Username != filter.Username:
+		return false
+	case filter.Endpoint != "" && !strings.HasPrefix(entry.Endpoint, filter.Endpoint):
+		return false
+	case filter.Status != 0 && entry.Status != filter.Status:
+		return false
+	case !filter.From.IsZero() && entry.OccurredAt.Before(filter.From):
+		return false
+	case !filter.To.IsZero() && !entry.OccurredAt.Before(filter.To):
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,163 @@
+package tax
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoreLoadSettings(t *testing.T) {
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	got, err := store.LoadSettings(context.Background())
+
+	require.NoError(t, err)
+	require.Equal(t, SettingsSnapshot{Version: 1, Allowances: DefaultAllowanceSettings}, got)
+}
+
+func TestMemoryStoreApproveProposal(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	proposal, err := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "alice"})
+	require.NoError(t, err)
+
+	_, _, err = store.ApproveProposal(ctx, proposal.ID, "alice", "")
+	require.ErrorIs(t, err, ErrSelfApproval)
+
+	approved, version, err := store.ApproveProposal(ctx, proposal.ID, "bob", "ok")
+	require.NoError(t, err)
+	require.Equal(t, proposalStatusApproved, approved.Status)
+	require.Equal(t, int64(2), *approved.AppliedVersion)
+	require.Equal(t, []AllowanceChange{{AllowanceType: "personal", OldAmount: 60000, NewAmount: 70000}}, version.Changes)
+
+	_, _, err = store.ApproveProposal(ctx, proposal.ID, "bob", "ok")
+	require.ErrorIs(t, err, ErrProposalNotPending)
+
+	settings, err := store.LoadSettings(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), settings.Version)
+	require.Equal(t, 70000.0, settings.Allowances.Personal)
+}
+
+func TestMemoryStoreApproveStaleProposal(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	first, _ := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "alice"})
+	second, _ := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "k-receipt", Amount: 80000, BaseVersion: 1, ProposedBy: "alice"})
+
+	_, _, err := store.ApproveProposal(ctx, first.ID, "bob", "")
+	require.NoError(t, err)
+
+	_, _, err = store.ApproveProposal(ctx, second.ID, "bob", "")
+
+	var changed *SettingsChangedError
+	require.ErrorAs(t, err, &changed)
+	require.Equal(t, int64(2), changed.Current.Version)
+
+	pending, err := store.ListProposals(ctx, proposalStatusPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+}
+
+func TestMemoryStoreRejectProposal(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	proposal, _ := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "personal", Amount: 70000, BaseVersion: 1, ProposedBy: "alice"})
+
+	rejected, err := store.RejectProposal(ctx, proposal.ID, "bob", "no")
+	require.NoError(t, err)
+	require.Equal(t, proposalStatusRejected, rejected.Status)
+
+	_, err = store.RejectProposal(ctx, 99, "bob", "no")
+	require.ErrorIs(t, err, ErrProposalNotFound)
+}
+
+func TestMemoryStoreRollback(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	proposal, _ := store.CreateProposal(ctx, DeductionProposal{AllowanceType: "k-receipt", Amount: 80000, BaseVersion: 1, ProposedBy: "alice"})
+	_, _, err := store.ApproveProposal(ctx, proposal.ID, "bob", "")
+	require.NoError(t, err)
+
+	rollbackTo := func(targetVersion, baseVersion int64) (DeductionProposal, AllowanceVersion, error) {
+		proposal, err := store.CreateProposal(ctx, DeductionProposal{RollbackOf: &targetVersion, BaseVersion: baseVersion, ProposedBy: "alice", Reason: "undo"})
+		require.NoError(t, err)
+		return store.ApproveProposal(ctx, proposal.ID, "bob", "")
+	}
+
+	_, _, err = rollbackTo(1, 1)
+	var changed *SettingsChangedError
+	require.ErrorAs(t, err, &changed)
+
+	_, _, err = rollbackTo(2, 2)
+	require.ErrorIs(t, err, ErrVersionAlreadyActive)
+
+	_, _, err = rollbackTo(9, anyVersion)
+	require.ErrorIs(t, err, ErrVersionNotFound)
+
+	approved, version, err := rollbackTo(1, 2)
+	require.NoError(t, err)
+	require.Equal(t, proposalStatusApproved, approved.Status)
+	require.Equal(t, int64(3), version.Version)
+	require.Equal(t, int64(1), *version.RollbackOf)
+	require.Equal(t, "bob", version.ChangedBy)
+	require.Equal(t, DefaultAllowanceSettings, version.Settings)
+
+	versions, err := store.ListVersions(ctx)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, int64(3), versions[0].Version)
+}
+
+func TestMemoryStoreScanAuditEntries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DefaultAllowanceSettings)
+
+	require.NoError(t, store.AppendAuditEntry(ctx, AuditEntry{Username: "adminTax", Endpoint: "/admin/deductions/personal", Status: 202}))
+	require.NoError(t, store.AppendAuditEntry(ctx, AuditEntry{Username: "mallory", Endpoint: "/admin/deductions/personal", Status: 401}))
+	require.NoError(t, store.AppendAuditEntry(ctx, AuditEntry{Username: "adminTax", Endpoint: "/admin/audit", Status: 200}))
+
+	entries, err := NewHandler(store, store).getAuditEntries(ctx, AuditFilter{Username: "adminTax"})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, int64(3), entries[0].ID)
+
+	entries, err = NewHandler(store, store).getAuditEntries(ctx, AuditFilter{Endpoint: "/admin/deductions", Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "adminTax", entries[0].Username)
+}
+
+func TestCalculateTaxWithMemoryStore(t *testing.T) {
+	store := NewMemoryStore(DefaultAllowanceSettings)
+	h := NewHandler(store, store)
+
+	e := echo.New()
+	requestBody := TaxInfo{
+		TotalIncome: 500000,
+		Allowances: []Allowances{
+			{AllowanceType: "donation", Amount: 200000},
+		},
+	}
+
+	rec, c := mockNewRequest(requestBody, t, e, "/tax/calculations")
+
+	err := h.CalculateTax(c)
+
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"tax": 19000, "taxLevel": [
+		{"level": "0-150,000", "tax": 0},
+		{"level": "150,001-500,000", "tax": 19000},
+		{"level": "500,001-1,000,000", "tax": 0},
+		{"level": "1,000,001-2,000,000", "tax": 0},
+		{"level": "2,000,001 ขึ้นไป", "tax": 0}
+	], "settingsVersion": 1}`, rec.Body.String())
+}
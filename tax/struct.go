@@ -12,13 +12,15 @@ type TaxInfo struct {
 }
 
 type TaxPayable struct {
-	Tax       float64    `json:"tax"`
-	TaxLevels []TaxLevel `json:"taxLevel"`
+	Tax             float64    `json:"tax"`
+	TaxLevels       []TaxLevel `json:"taxLevel"`
+	SettingsVersion int64      `json:"settingsVersion"`
 }
 
 type TaxReturnable struct {
-	TaxRefund float64    `json:"taxRefund"`
-	TaxLevels []TaxLevel `json:"taxLevel"`
+	TaxRefund       float64    `json:"taxRefund"`
+	TaxLevels       []TaxLevel `json:"taxLevel"`
+	SettingsVersion int64      `json:"settingsVersion"`
 }
 
 type TaxLevel struct {
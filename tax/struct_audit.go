@@ -0,0 +1,32 @@
+package tax
+
+import "time"
+
+type AuditEntry struct {
+	ID            int64     `json:"id"`
+	OccurredAt    time.Time `json:"occurredAt"`
+	Username      string    `json:"username"`
+	Authenticated bool      `json:"authenticated"`
+	SourceIP      string    `json:"sourceIp"`
+	RequestID     string    `json:"requestId"`
+	Method        string    `json:"method"`
+	Endpoint      string    `json:"endpoint"`
+	Payload       string    `json:"payload"`
+	Status        int       `json:"status"`
+}
+
+type AuditFilter struct {
+	Username string
+	Endpoint string
+	Status   int
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+type AuditLogResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+}
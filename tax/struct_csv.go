@@ -1,11 +1,89 @@
 package tax
 
 type TaxCSV struct {
-	TotalIncome float64 `json:"totalIncome"`
-	Tax         float64 `json:"tax"`
-	TaxRefund   float64 `json:"taxRefund"`
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+	TotalIncome float64           `json:"totalIncome"`
+	Tax         float64           `json:"tax"`
+	TaxRefund   float64           `json:"taxRefund"`
+	Breakdown   *TaxCSVBreakdown  `json:"breakdown,omitempty"`
+}
+
+// TaxCSVBreakdown explains the tax of a row, EffectiveRate is the tax before
+// wht as a percentage of the total income.
+type TaxCSVBreakdown struct {
+	Allowances    []Allowances `json:"allowances"`
+	NetIncome     float64      `json:"netIncome"`
+	EffectiveRate float64      `json:"effectiveRate"`
+	TaxLevels     []TaxLevel   `json:"taxLevel"`
+}
+
+func (b *TaxCSVBreakdown) values() []float64 {
+	allowances := map[string]float64{}
+	for _, allowance := range b.Allowances {
+		allowances[allowance.AllowanceType] = allowance.Amount
+	}
+
+	values := []float64{allowances["personal"], allowances["donation"], allowances["k-receipt"], b.NetIncome, b.EffectiveRate}
+	for _, level := range b.TaxLevels {
+		values = append(values, level.Tax)
+	}
+
+	return values
 }
 
 type TaxResponseCSV struct {
-	Taxes []TaxCSV `json:"taxes"`
+	Taxes           []TaxCSV      `json:"taxes"`
+	Errors          []CSVRowError `json:"errors,omitempty"`
+	Summary         TaxCSVSummary `json:"summary"`
+	SettingsVersion int64         `json:"settingsVersion"`
+}
+
+// TaxCSVSummary totals the valid rows of an upload. Rows counts the valid
+// rows and InvalidRows the rows that could not be calculated.
+// AverageEffectiveRate is the mean of the effective rates of the rows,
+// CappedRows counts rows where an allowance was limited by its cap.
+type TaxCSVSummary struct {
+	Rows                 int               `json:"rows"`
+	InvalidRows          int               `json:"invalidRows"`
+	TotalIncome          float64           `json:"totalIncome"`
+	TotalTax             float64           `json:"totalTax"`
+	TotalTaxRefund       float64           `json:"totalTaxRefund"`
+	AverageEffectiveRate float64           `json:"averageEffectiveRate"`
+	TaxLevels            []TaxLevelSummary `json:"taxLevel"`
+	CappedRows           int               `json:"cappedRows"`
+}
+
+// TaxLevelSummary counts the rows whose net income reaches a level and sums
+// their tax before wht.
+type TaxLevelSummary struct {
+	Level string  `json:"level"`
+	Rows  int     `json:"rows"`
+	Tax   float64 `json:"tax"`
+}
+
+type CSVRowError struct {
+	Line        int               `json:"line"`
+	Identifiers map[string]string `json:"identifiers,omitempty"`
+	Column      string            `json:"column,omitempty"`
+	Value       string            `json:"value"`
+	Reason      string            `json:"reason"`
+}
+
+type CSVHeaderError struct {
+	Message          string   `json:"message"`
+	UnknownColumns   []string `json:"unknownColumns,omitempty"`
+	MissingColumns   []string `json:"missingColumns,omitempty"`
+	DuplicateColumns []string `json:"duplicateColumns,omitempty"`
+}
+
+func (e *CSVRowError) Error() string {
+	return e.Reason
+}
+
+// TaxCSVLine is one line of the NDJSON output, either a result, an error or
+// the summary that ends a complete stream.
+type TaxCSVLine struct {
+	*TaxCSV
+	Error   *CSVRowError   `json:"error,omitempty"`
+	Summary *TaxCSVSummary `json:"summary,omitempty"`
 }
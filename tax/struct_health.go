@@ -0,0 +1,16 @@
+package tax
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+type HealthResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks,omitempty"`
+}
+
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,31 @@
+package tax
+
+import "time"
+
+const (
+	jobStatusQueued    = "queued"
+	jobStatusRunning   = "running"
+	jobStatusSucceeded = "succeeded"
+	jobStatusFailed    = "failed"
+	jobStatusCancelled = "cancelled"
+)
+
+type BatchJob struct {
+	ID            string     `json:"id"`
+	Status        string     `json:"status"`
+	Filename      string     `json:"filename"`
+	Mode          string     `json:"mode"`
+	Sheet         string     `json:"sheet,omitempty"`
+	Detail        bool       `json:"detail"`
+	TotalRows     int        `json:"totalRows"`
+	ProcessedRows int        `json:"processedRows"`
+	InvalidRows   int        `json:"invalidRows"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	FinishedAt    *time.Time `json:"finishedAt,omitempty"`
+}
+
+func (job BatchJob) finished() bool {
+	return job.Status == jobStatusSucceeded || job.Status == jobStatusFailed || job.Status == jobStatusCancelled
+}
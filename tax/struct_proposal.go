@@ -0,0 +1,33 @@
+package tax
+
+import "time"
+
+const (
+	proposalStatusPending  = "pending"
+	proposalStatusApproved = "approved"
+	proposalStatusRejected = "rejected"
+)
+
+type DeductionProposal struct {
+	ID             int64      `json:"id"`
+	AllowanceType  string     `json:"allowanceType,omitempty"`
+	Amount         float64    `json:"amount"`
+	RollbackOf     *int64     `json:"rollbackOf,omitempty"`
+	Reason         string     `json:"reason"`
+	BaseVersion    int64      `json:"baseVersion"`
+	Status         string     `json:"status"`
+	ProposedBy     string     `json:"proposedBy"`
+	ProposedAt     time.Time  `json:"proposedAt"`
+	DecidedBy      string     `json:"decidedBy,omitempty"`
+	DecidedAt      *time.Time `json:"decidedAt,omitempty"`
+	DecisionReason string     `json:"decisionReason,omitempty"`
+	AppliedVersion *int64     `json:"appliedVersion,omitempty"`
+}
+
+type DeductionProposalsResponse struct {
+	Proposals []DeductionProposal `json:"proposals"`
+}
+
+type ProposalDecisionRequest struct {
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,56 @@
+package tax
+
+import "time"
+
+type AllowanceSettings struct {
+	Personal float64 `json:"personal"`
+	Donation float64 `json:"donation"`
+	KReceipt float64 `json:"kReceipt"`
+}
+
+// DeductionLimit is the range an admin may set the cap of a deduction type to.
+type DeductionLimit struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+type AllowanceAmountRequest struct {
+	Amount float64 `json:"amount"`
+	Reason string  `json:"reason"`
+}
+
+type AllowanceRollbackRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AllowanceVersion struct {
+	Version         int64             `json:"version"`
+	Settings        AllowanceSettings `json:"settings"`
+	ChangedBy       string            `json:"changedBy"`
+	ChangedAt       time.Time         `json:"changedAt"`
+	Reason          string            `json:"reason"`
+	PreviousVersion *int64            `json:"previousVersion,omitempty"`
+	RollbackOf      *int64            `json:"rollbackOf,omitempty"`
+	Changes         []AllowanceChange `json:"changes"`
+}
+
+type AllowanceChange struct {
+	AllowanceType string  `json:"allowanceType"`
+	OldAmount     float64 `json:"oldAmount"`
+	NewAmount     float64 `json:"newAmount"`
+}
+
+type AllowanceVersionsResponse struct {
+	Versions []AllowanceVersion `json:"versions"`
+}
+
+type AllowanceVersionDiff struct {
+	From    int64             `json:"from"`
+	To      int64             `json:"to"`
+	Changes []AllowanceChange `json:"changes"`
+}
+
+type SettingsSnapshot struct {
+	Version    int64             `json:"version"`
+	Allowances AllowanceSettings `json:"allowances"`
+}